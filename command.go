@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// rerunTickMsg asks to run the command again when it repeats on an interval
+type rerunTickMsg struct{}
+
+func rerunTick(interval time.Duration) tea.Cmd {
+	return tea.Tick(interval, func(time.Time) tea.Msg {
+		return rerunTickMsg{}
+	})
+}
+
+// loadCommand runs args and loads what it prints to stdout like stdin. The
+// command gets no input, and its stderr is kept to explain a failure.
+func loadCommand(args []string, opts loadOptions, progress func(read, total int64)) (*document, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("running %s: %v", args[0], err)
+	}
+
+	doc, loadErr := loadPipe(out, args[0], opts, progress)
+	if loadErr != nil {
+		// A load that failed early leaves a command that may keep writing,
+		// or never exit, so it is stopped rather than waited for. Closing
+		// its stdout stops what it started too, and WaitDelay bounds the
+		// wait for anything still holding its stderr.
+		cmd.Process.Kill()
+		out.Close()
+		cmd.WaitDelay = time.Second
+		err := cmd.Wait()
+		if err != nil && cmd.ProcessState.ExitCode() != -1 && !errors.Is(err, exec.ErrWaitDelay) {
+			// It had failed by itself, which explains the output
+			return nil, commandError(args, err, stderr.String())
+		}
+		return nil, loadErr
+	}
+	if err := cmd.Wait(); err != nil {
+		doc.Close()
+		return nil, commandError(args, err, stderr.String())
+	}
+	return doc, nil
+}
+
+// commandError reports that the command args failed with err, and why
+// according to the last line of its stderr
+func commandError(args []string, err error, stderr string) error {
+	if msg := lastLine(stderr); msg != "" {
+		return fmt.Errorf("running %s: %v: %s", args[0], err, msg)
+	}
+	return fmt.Errorf("running %s: %v", args[0], err)
+}
+
+// lastLine returns the last line of s that is not blank, which is where
+// commands usually say why they failed
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// commandLine renders args as a shell command
+func commandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// parseInterval reads the repeat interval of a command, in seconds like
+// watch -n or as a duration such as 1m30s
+func parseInterval(s string) (time.Duration, error) {
+	interval, err := time.ParseDuration(s)
+	if err != nil {
+		seconds, floatErr := strconv.ParseFloat(s, 64)
+		if floatErr != nil {
+			return 0, fmt.Errorf("invalid interval %q: use seconds, like 5, or a duration, like 1m30s", s)
+		}
+		interval = time.Duration(seconds * float64(time.Second))
+	}
+	if interval < 100*time.Millisecond {
+		return 0, fmt.Errorf("invalid interval %q: the shortest is 0.1 seconds", s)
+	}
+	return interval, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadCommand(t *testing.T) {
+	doc, err := loadCommand([]string{"sh", "-c", `echo 'kind: Pod'; echo 'name: api'`}, loadOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.source != sourceYAML || len(doc.root.Children) != 2 {
+		t.Errorf("Expected the YAML output to be read, got source %v with %d keys", doc.source, len(doc.root.Children))
+	}
+
+	_, err = loadCommand([]string{"sh", "-c", `echo '{"a": 1}'; echo 'error: forbidden' >&2; exit 3`}, loadOptions{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3: error: forbidden") {
+		t.Errorf("Expected the failure with the last line of stderr, got %v", err)
+	}
+
+	_, err = loadCommand([]string{"sh", "-c", `echo '{"a": '`}, loadOptions{}, nil)
+	if asParseError(err) == nil {
+		t.Errorf("Expected a parse error for broken output, got %v", err)
+	}
+
+	if _, err := loadCommand([]string{"jqpick-no-such-command"}, loadOptions{}, nil); err == nil {
+		t.Error("Expected an error for a missing command")
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{"5", 5 * time.Second},
+		{"0.5", 500 * time.Millisecond},
+		{"1m30s", 90 * time.Second},
+		{"0", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		got, err := parseInterval(tt.input)
+		if got != tt.expected || (err != nil) != (tt.expected == 0) {
+			t.Errorf("parseInterval(%q) = %v, %v; expected %v", tt.input, got, err, tt.expected)
+		}
+	}
+}
+
+func TestExampleCommandRunsCommand(t *testing.T) {
+	m := model{
+		command: []string{"kubectl", "get", "pods", "-l", "app in (api)", "-o", "json"},
+		doc:     &document{source: sourceJSON},
+	}
+	want := "kubectl get pods -l 'app in (api)' -o json | jq '.items[0]'"
+	if got := m.exampleCommand(".items[0]"); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestLoadCommandStopsEarly(t *testing.T) {
+	commands := []string{
+		// Corrupt compressed output fails before the command has written it all
+		`printf '\037\213broken'; head -c 1000000 /dev/zero`,
+		// or while the command keeps running
+		`printf '\037\213broken'; head -c 100 /dev/zero; sleep 30`,
+	}
+	for _, script := range commands {
+		done := make(chan error, 1)
+		go func() {
+			_, err := loadCommand([]string{"sh", "-c", script}, loadOptions{}, nil)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err == nil || strings.Contains(err.Error(), "killed") {
+				t.Errorf("Expected the error of the corrupt output, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", script)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compression is a compressed container recognized by its magic bytes
+type compression struct {
+	name    string
+	magic   []byte
+	digit   bool   // the magic is followed by a digit from 1 to 9, bzip2's block size
+	ext     string // file name extension, stripped to detect the inner syntax
+	command string // decompresses a file or stdin to stdout, for example commands
+	open    func(r io.Reader) (io.ReadCloser, error)
+}
+
+var compressions = []*compression{
+	{
+		name:    "gzip",
+		magic:   []byte{0x1f, 0x8b},
+		ext:     ".gz",
+		command: "zcat",
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	{
+		name:    "zstd",
+		magic:   []byte{0x28, 0xb5, 0x2f, 0xfd},
+		ext:     ".zst",
+		command: "zstdcat",
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		},
+	},
+	{
+		name:    "bzip2",
+		magic:   []byte("BZh"),
+		digit:   true,
+		ext:     ".bz2",
+		command: "bzcat",
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(bzip2.NewReader(r)), nil
+		},
+	},
+}
+
+// magicLen is the number of leading bytes needed to detect a compression
+const magicLen = 4
+
+// detectCompression returns the compression whose magic bytes start head, or
+// nil for uncompressed input
+func detectCompression(head []byte) *compression {
+	for _, c := range compressions {
+		if !bytes.HasPrefix(head, c.magic) {
+			continue
+		}
+		if c.digit && (len(head) <= len(c.magic) || head[len(c.magic)] < '1' || head[len(c.magic)] > '9') {
+			continue
+		}
+		return c
+	}
+	return nil
+}
+
+// trimCompressionExt removes the compression extension from a file name, so
+// logs.ndjson.gz is detected as JSON Lines
+func trimCompressionExt(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, c := range compressions {
+		if ext == c.ext {
+			return path[:len(path)-len(ext)]
+		}
+	}
+	return path
+}
+
+// decompressReader names the compression in errors of the decompressed
+// stream, such as a truncated file ending with an unexpected EOF
+type decompressReader struct {
+	r    io.Reader
+	name string
+}
+
+func (d *decompressReader) Read(b []byte) (int, error) {
+	n, err := d.r.Read(b)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%s: %w", d.name, err)
+	}
+	return n, err
+}
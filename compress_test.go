@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// records is bzip2Records decompressed
+const records = "{\"id\":1}\n{\"id\":2}\n"
+
+// bzip2Records was written by the bzip2 tool, as the standard library has no
+// bzip2 encoder
+const bzip2Records = "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x32\x98\x4d\xda\x00\x00\x07\x59\x80\x00\x10\x10\x00\x30\x10\x04\x20\x00\x0a\x20\x00\x21\x28\x04\xfd\x50\x83\x26\x21\x38\x4f\x1a\x24\x9c\x2f\xc5\xdc\x91\x4e\x14\x24\x0c\xa6\x13\x76\x80"
+
+func gzipBytes(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(data))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data string) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll([]byte(data), nil)
+}
+
+func TestLoadCompressedInput(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		data    []byte
+		command string
+	}{
+		{"logs.ndjson.gz", gzipBytes(t, records), "zcat"},
+		{"logs.ndjson.zst", zstdBytes(t, records), "zstdcat"},
+		{"logs.ndjson.bz2", []byte(bzip2Records), "bzcat"},
+		// Detected from the contents, not the name
+		{"logs", gzipBytes(t, records), "zcat"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		doc, err := loadInput(path, loadOptions{}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if doc.compressed == nil || doc.compressed.command != tt.command {
+			t.Errorf("%s: expected to be decompressed with %s, got %+v", tt.name, tt.command, doc.compressed)
+		}
+		if len(doc.root.Children) != 2 {
+			t.Fatalf("%s: expected 2 records, got %d", tt.name, len(doc.root.Children))
+		}
+		if preview := doc.root.Children[1].Children[0].getValuePreview(); preview != "2" {
+			t.Errorf("%s: expected the second id to be 2, got %s", tt.name, preview)
+		}
+		if line := doc.root.Children[1].Line; line != 2 {
+			t.Errorf("%s: expected the second record on line 2, got %d", tt.name, line)
+		}
+		doc.Close()
+	}
+}
+
+func TestLoadCompressedDetectsInnerFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.yaml.gz")
+	if err := os.WriteFile(path, gzipBytes(t, "kind: Deployment\nreplicas: 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := loadInput(path, loadOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.source != sourceYAML {
+		t.Errorf("Expected deploy.yaml.gz to be read as YAML, got %v", doc.source)
+	}
+
+	m := model{filename: path, doc: doc}
+	want := "zcat " + shellQuote(path) + " | yq -o=json | jq '.kind'"
+	if got := m.exampleCommand(".kind"); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestLoadCorruptCompressedInput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.json.gz")
+	data := gzipBytes(t, records)
+	if err := os.WriteFile(path, data[:len(data)-6], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadInput(path, loadOptions{}, nil); err == nil || !strings.Contains(err.Error(), "gzip") {
+		t.Errorf("Expected a gzip error for a truncated file, got %v", err)
+	}
+}
+
+func TestExampleCommandCompressedStdin(t *testing.T) {
+	m := model{doc: &document{source: sourceJSON, compressed: compressions[1]}}
+	if got, want := m.exampleCommand(".id"), "zstdcat file.json.zst | jq '.id'"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	tests := []struct {
+		head     string
+		expected string
+	}{
+		{bzip2Records[:magicLen], "bzip2"},
+		{string(gzipBytes(t, records)[:magicLen]), "gzip"},
+		{string(zstdBytes(t, records)[:magicLen]), "zstd"},
+		// Text that merely starts like bzip2
+		{"BZh:", ""},
+		{"BZh0", ""},
+		{"BZh", ""},
+		{`{"a"`, ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if c := detectCompression([]byte(tt.head)); c != nil {
+			got = c.name
+		}
+		if got != tt.expected {
+			t.Errorf("detectCompression(%q) = %q, expected %q", tt.head, got, tt.expected)
+		}
+	}
+}
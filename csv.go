@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// decodeCSV reads delimited text whose first row names the columns into an
+// array with one object per row. Header names are used as they are, with
+// repeated names numbered name_2, name_3 and so on. Short rows get null for
+// the missing cells. With infer set, cells that are JSON numbers or booleans
+// get those types and empty cells become null.
+func decodeCSV(data []byte, comma rune, infer bool) (interface{}, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\uFEFF"))))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("no header row")
+	} else if err != nil {
+		return nil, err
+	}
+	keys := columnKeys(header)
+
+	rows := []interface{}{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(record) > len(keys) {
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %d fields, but the header has %d", line, len(record), len(keys))
+		}
+
+		row := make(orderedObject, len(keys))
+		for i, key := range keys {
+			row[i].Key = key
+			if i < len(record) {
+				row[i].Value = csvValue(record[i], infer)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// columnKeys returns the object keys for a header row
+func columnKeys(header []string) []string {
+	keys := make([]string, len(header))
+	used := make(map[string]bool)
+	for i, name := range header {
+		key := name
+		for n := 2; used[key]; n++ {
+			key = name + "_" + strconv.Itoa(n)
+		}
+		used[key] = true
+		keys[i] = key
+	}
+	return keys
+}
+
+func csvValue(cell string, infer bool) interface{} {
+	if !infer {
+		return cell
+	}
+	switch {
+	case cell == "":
+		return nil
+	case cell == "true":
+		return true
+	case cell == "false":
+		return false
+	case isJSONNumber(cell):
+		return json.Number(cell)
+	default:
+		return cell
+	}
+}
+
+// csvQuoted reports whether any field of data starts with a quote, so that
+// splitting its lines on the delimiter would not give the same cells
+func csvQuoted(data []byte, comma rune) bool {
+	data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+	for i, c := range data {
+		if c == '"' && (i == 0 || rune(data[i-1]) == comma || data[i-1] == '\n' || data[i-1] == '\r') {
+			return true
+		}
+	}
+	return false
+}
+
+// csvConversion is a jq program that turns delimited text read with -Rn into
+// the array jqpick shows, so queries built on it can be run with jq alone.
+// It splits lines on the delimiter and does not understand quoted fields,
+// so it is not offered for input where csvQuoted finds any.
+// Like decodeCSV it drops a byte order mark and blank lines, and repeated
+// header names are numbered like columnKeys does.
+func csvConversion(comma rune, infer bool) string {
+	program := fmt.Sprintf(`[inputs | rtrimstr("\r") | select(. != "")] | .[0] |= ltrimstr("\ufeff") | map(split(%s)) | (.[0] | reduce .[] as $n ([]; . as $k | . + [first($n, $n + "_" + (range(2; infinite) | tostring) | select(. as $c | $k | any(.[]; . == $c) | not))])) as $h | .[1:] | map([$h, .] | transpose | map({key: .[0], value: .[1]}) | from_entries)`,
+		quoteJSONString(string(comma)))
+	if infer {
+		program += ` | map(with_entries(.value = (.value | if . == "" then null elif . == "true" or . == "false" then . == "true" elif type == "string" and test("^\\s|\\s$") then . else (tonumber? // .) end)))`
+	}
+	return program
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCSV(t *testing.T) {
+	input := "\uFEFFid,name,active,note,id\r\n1,\"Doe, John\",true,,x\r\n2,Jane,false\r\n"
+	data, err := decodeCSV([]byte(input), ',', false)
+	if err != nil {
+		t.Fatalf("decodeCSV: %v", err)
+	}
+
+	root := buildJSONTree(data, nil, "")
+	if len(root.Children) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(root.Children))
+	}
+	if got := strings.Join(childKeys(root.Children[0]), ","); got != "id,name,active,note,id_2" {
+		t.Errorf("Unexpected keys %s", got)
+	}
+	first, second := root.Children[0], root.Children[1]
+	if got := first.Children[1].getValuePreview(); got != `"Doe, John"` {
+		t.Errorf("Expected quoted field, got %s", got)
+	}
+	if got := first.Children[0].getValuePreview(); got != `"1"` {
+		t.Errorf("Expected cells to stay strings, got %s", got)
+	}
+	if got := second.Children[3].getValuePreview(); got != "null" {
+		t.Errorf("Expected a missing cell to be null, got %s", got)
+	}
+
+	typed, err := decodeCSV([]byte(input), ',', true)
+	if err != nil {
+		t.Fatalf("decodeCSV: %v", err)
+	}
+	row := buildJSONTree(typed, nil, "").Children[0]
+	for i, expected := range []string{"1", `"Doe, John"`, "true", "null"} {
+		if got := row.Children[i].getValuePreview(); got != expected {
+			t.Errorf("%s: expected %s, got %s", row.Children[i].Key, expected, got)
+		}
+	}
+}
+
+func TestDecodeCSVErrors(t *testing.T) {
+	if _, err := decodeCSV(nil, ',', false); err == nil {
+		t.Errorf("Expected an error for empty input")
+	}
+	if _, err := decodeCSV([]byte("a\tb\n1\t2\t3\n"), '\t', false); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error for a long row, got %v", err)
+	}
+}
+
+func TestCSVConversionMatchesDecoder(t *testing.T) {
+	input := "\uFEFFid\tname\tname\tname_2\tscore\n1\tJohn\tJ\tx\t\n\n2\tJane\tJ\ty\t9.5\n3\tJim\n4\tJoe\tJ\tz\t8 \n"
+	for _, infer := range []bool{false, true} {
+		data, err := decodeCSV([]byte(input), '\t', infer)
+		if err != nil {
+			t.Fatalf("decodeCSV: %v", err)
+		}
+		expected, _ := buildJSONTree(data, nil, "").compactJSON()
+
+		// jq -Rn feeds the lines to inputs; here they come from an array
+		lines := []interface{}{}
+		for _, line := range strings.Split(strings.TrimSuffix(input, "\n"), "\n") {
+			lines = append(lines, line)
+		}
+		program := strings.Replace(csvConversion('\t', infer), "inputs", ".[]", 1)
+		result, err := evalFilter(program, lines)
+		if err != nil {
+			t.Fatalf("evalFilter: %v", err)
+		}
+		if got, _ := result.compactJSON(); got != expected {
+			t.Errorf("infer=%v: expected %s, got %s", infer, expected, got)
+		}
+
+		// Older jq releases differ from gojq, so the program is run by jq
+		// itself when it is installed
+		if _, err := exec.LookPath("jq"); err != nil {
+			continue
+		}
+		cmd := exec.Command("jq", "-c", "-Rn", csvConversion('\t', infer))
+		cmd.Stdin = strings.NewReader(input)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("jq: %v", err)
+		}
+		if got := strings.TrimSpace(string(out)); got != expected {
+			t.Errorf("infer=%v: expected jq to print %s, got %s", infer, expected, got)
+		}
+	}
+}
+
+func TestCSVQuoted(t *testing.T) {
+	tests := []struct {
+		input    string
+		comma    rune
+		expected bool
+	}{
+		{"id,name\n1,John\n", ',', false},
+		{"id,name\n1,5\" disk\n", ',', false},
+		{"\"id\",\"name\"\n1,John\n", ',', true},
+		{"\uFEFF\"id\",name\n", ',', true},
+		{"id,name\r\n\"1,2\",x\r\n", ',', true},
+		{"id\tname\n1\t\"a\tb\"\n", '\t', true},
+	}
+	for _, tt := range tests {
+		if got := csvQuoted([]byte(tt.input), tt.comma); got != tt.expected {
+			t.Errorf("csvQuoted(%q) = %v, expected %v", tt.input, got, tt.expected)
+		}
+	}
+
+	doc, err := parseDocument([]byte("\"id\",\"name\"\n1,John\n"), loadOptions{source: sourceCSV})
+	if err != nil || !doc.quotedCSV {
+		t.Errorf("Expected the document to note its quoted fields, got %v", err)
+	}
+}
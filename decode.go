@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// objectField is a single key/value pair of a JSON object
+type objectField struct {
+	Key   string
+	Value interface{}
+}
+
+// orderedObject is a JSON object that remembers the document order of its keys
+type orderedObject []objectField
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := marshalJSON(field.Key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := marshalJSON(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// marshalJSON encodes v as compact JSON the way jq prints it: <, > and &
+// are not escaped for HTML, nor are the line and paragraph separators
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	data := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	if !bytes.Contains(data, []byte(`\u202`)) {
+		return data, nil
+	}
+
+	// encoding/json escapes U+2028 and U+2029 even without HTML escaping
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		switch {
+		case data[i] != '\\':
+			out = append(out, data[i])
+		case bytes.HasPrefix(data[i:], []byte(`\u2028`)):
+			out = append(out, "\u2028"...)
+			i += len(`\u2028`) - 1
+		case bytes.HasPrefix(data[i:], []byte(`\u2029`)):
+			out = append(out, "\u2029"...)
+			i += len(`\u2029`) - 1
+		default:
+			// Keep the escaped character, which may be a backslash
+			out = append(out, data[i:i+2]...)
+			i++
+		}
+	}
+	return out, nil
+}
+
+// decodeJSON decodes exactly one JSON value from data, keeping object keys in
+// document order
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		offset := dec.InputOffset()
+		return nil, &offsetError{offset: offset, msg: fmt.Sprintf("invalid character after top-level value at offset %d", offset)}
+	}
+	return value, nil
+}
+
+// decodeJSONStream decodes a sequence of JSON values, as jq reads its input:
+// values may be separated by any whitespace or by none at all, so both JSON
+// Lines and back-to-back pretty-printed objects are accepted. The line each
+// value starts on is returned alongside it.
+func decodeJSONStream(data []byte) ([]interface{}, []int, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var (
+		values []interface{}
+		lines  []int
+	)
+	for dec.More() {
+		// More has skipped the whitespace before the value
+		start := dec.InputOffset()
+		value, err := decodeValue(dec)
+		if err != nil {
+			return nil, nil, streamError(data, dec, start, err)
+		}
+		values = append(values, value)
+		lines = append(lines, lineAt(data, start))
+	}
+	// More stops at a stray closing bracket as well as at the end
+	if _, err := dec.Token(); err != io.EOF {
+		offset := dec.InputOffset()
+		return nil, nil, &offsetError{offset: offset, msg: fmt.Sprintf("line %d: unexpected closing bracket", lineAt(data, offset))}
+	}
+	return values, lines, nil
+}
+
+// decodeJSONPrefix decodes the valid beginning of a broken JSON input: the
+// values of a stream before the one that failed, and of that value whatever
+// was read, with its open objects and arrays cut short. Several values are
+// returned as an array; ok is false when nothing was read.
+func decodeJSONPrefix(data []byte) (value interface{}, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var values []interface{}
+decode:
+	for dec.More() {
+		v, err := decodeValue(dec)
+		if err == nil {
+			values = append(values, v)
+			continue
+		}
+		// Keep the broken value if any of it could be read
+		switch v := v.(type) {
+		case orderedObject:
+			if len(v) > 0 {
+				values = append(values, v)
+			}
+		case []interface{}:
+			if len(v) > 0 {
+				values = append(values, v)
+			}
+		}
+		break decode
+	}
+
+	switch len(values) {
+	case 0:
+		return nil, false
+	case 1:
+		return values[0], true
+	default:
+		return values, true
+	}
+}
+
+// lineError is a line of a JSON Lines input that could not be decoded
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e lineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+// decodeJSONLines decodes one JSON value per line, with no limit on the
+// length of a line. Blank lines are ignored, and lines that fail to decode
+// are skipped and returned as errors instead of failing the whole input.
+func decodeJSONLines(data []byte) (values []interface{}, lines []int, skipped []lineError, err error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	for lineNum := 1; ; lineNum++ {
+		line, readErr := r.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return nil, nil, nil, readErr
+		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if value, err := decodeJSON(trimmed); err != nil {
+				skipped = append(skipped, lineError{line: lineNum, err: err})
+			} else {
+				values = append(values, value)
+				lines = append(lines, lineNum)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if len(values) == 0 {
+		return nil, nil, skipped, errors.New("no valid JSON lines found")
+	}
+	return values, lines, skipped, nil
+}
+
+// firstLineIsValue reports whether the first non-blank line of data holds a
+// complete JSON value, as in JSON Lines
+func firstLineIsValue(data []byte) bool {
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			_, err := decodeJSON(line)
+			return err == nil
+		}
+	}
+	return false
+}
+
+// streamError reports where in data decoding a value that started at start
+// failed
+func streamError(data []byte, dec *json.Decoder, start int64, err error) error {
+	offset := dec.InputOffset()
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErrorOffset(data, syntaxErr)
+	case err == io.EOF, err == io.ErrUnexpectedEOF:
+		return &offsetError{
+			offset: int64(len(data)),
+			msg:    fmt.Sprintf("line %d: value is not terminated before the end of input", lineAt(data, start)),
+		}
+	}
+	return &offsetError{offset: offset, msg: fmt.Sprintf("line %d: %v", lineAt(data, offset), err)}
+}
+
+// syntaxErrorOffset returns the offset of the character a syntax error
+// names. encoding/json reports most errors after reading that character, but
+// some before it.
+func syntaxErrorOffset(data []byte, err *json.SyntaxError) int64 {
+	offset := err.Offset
+	quoted, ok := strings.CutPrefix(err.Error(), "invalid character ")
+	if !ok || offset > int64(len(data)) {
+		return offset
+	}
+	if end := strings.IndexByte(quoted[1:], '\'') + 2; end > 1 {
+		// Quoted as a Go character literal; a quote is written '\''
+		if quoted[:4] == `'\''` {
+			end = 4
+		}
+		if c, err := strconv.Unquote(quoted[:end]); err == nil && bytes.HasSuffix(data[:offset], []byte(c)) {
+			offset -= int64(len(c))
+		}
+	}
+	return offset
+}
+
+// lineAt returns the 1-based line number of the byte at offset
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte{'\n'}) + 1
+}
+
+// decodeValue reads the next JSON value from dec. Objects become
+// orderedObject, arrays []interface{}, and scalars keep encoding/json types
+// (numbers are json.Number when dec.UseNumber is set). On error, the object
+// or array being read is returned with the elements read so far.
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+
+	switch delim {
+	case '{':
+		obj := orderedObject{}
+		seen := make(map[string]int)
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return obj, err
+			}
+			key := keyTok.(string)
+			value, err := decodeValue(dec)
+			if err != nil && value == nil {
+				return obj, err
+			}
+			// Like encoding/json, the last duplicate key wins
+			if i, dup := seen[key]; dup {
+				obj[i].Value = value
+			} else {
+				seen[key] = len(obj)
+				obj = append(obj, objectField{Key: key, Value: value})
+			}
+			if err != nil {
+				return obj, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return obj, err
+		}
+		return obj, nil
+	case '[':
+		arr := []interface{}{}
+		for dec.More() {
+			value, err := decodeValue(dec)
+			if err != nil && value == nil {
+				return arr, err
+			}
+			arr = append(arr, value)
+			if err != nil {
+				return arr, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return arr, err
+		}
+		return arr, nil
+	default:
+		return nil, fmt.Errorf("unexpected %q at offset %d", delim, dec.InputOffset())
+	}
+}
+
+// numberLosesPrecision reports whether num changes value when parsed into a
+// float64 and printed back, e.g. 64-bit IDs above 2^53
+func numberLosesPrecision(num json.Number) bool {
+	f, err := strconv.ParseFloat(string(num), 64)
+	if err != nil {
+		return true // out of range
+	}
+	exact, ok := new(big.Rat).SetString(string(num))
+	if !ok {
+		return false
+	}
+	rounded, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return ok && exact.Cmp(rounded) != 0
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	excerptContext = 2  // lines shown before and after the line of an error
+	excerptWidth   = 80 // bytes of a line shown in an excerpt
+)
+
+// offsetError is a decoding error at a known byte offset of the input
+type offsetError struct {
+	offset int64
+	msg    string
+}
+
+func (e *offsetError) Error() string {
+	return e.msg
+}
+
+// parseError is an input that failed to parse, with where it failed and the
+// lines around that place
+type parseError struct {
+	format  string // syntax the input was parsed as
+	err     error
+	offset  int64 // byte offset of the failure, -1 when unknown
+	line    int
+	column  int
+	excerpt []excerptLine
+	partial interface{} // the valid beginning of the input, nil when there is none
+}
+
+func (e *parseError) Error() string {
+	return fmt.Sprintf("parsing %s: %v", e.format, e.err)
+}
+
+func (e *parseError) Unwrap() error {
+	return e.err
+}
+
+// excerptLine is a line of the input shown around a parse error. Mark is the
+// byte of text the error points at, or -1 on the other lines and when the
+// error only names its line.
+type excerptLine struct {
+	number int
+	text   string
+	mark   int
+	failed bool // the line of the error
+}
+
+// position describes where the error is, as "line 3, column 7"
+func (e *parseError) position() string {
+	if e.column == 0 {
+		return fmt.Sprintf("line %d", e.line)
+	}
+	return fmt.Sprintf("line %d, column %d", e.line, e.column)
+}
+
+// newParseError locates err in data, the input it came from
+func newParseError(format string, err error, data []byte) *parseError {
+	offset, exact := errorOffset(err, data)
+	e := locateError(format, err, offset, bytes.NewReader(data))
+	if !exact {
+		e.column = 0
+		for i := range e.excerpt {
+			e.excerpt[i].mark = -1
+		}
+	}
+	return e
+}
+
+// indexError locates an error of indexing src, which is only read as far as
+// the lines after the error
+func indexError(err error, src io.ReaderAt, size int64) *parseError {
+	offset, _ := errorOffset(err, nil)
+	return locateError("JSON", err, offset, io.NewSectionReader(src, 0, size))
+}
+
+func locateError(format string, err error, offset int64, r io.Reader) *parseError {
+	e := &parseError{format: format, err: err, offset: offset}
+	if offset >= 0 {
+		e.line, e.column, e.excerpt = locate(r, offset)
+	}
+	return e
+}
+
+// further returns whichever of e and other got further into the input. When
+// the input is tried in several syntaxes, that one is the error to report:
+// the others failed on something the input was not meant to be.
+func (e *parseError) further(other *parseError) *parseError {
+	if other != nil && other.offset > e.offset {
+		return other
+	}
+	return e
+}
+
+// lineNumber finds the line number in messages such as "yaml: line 3: ..."
+var lineNumber = regexp.MustCompile(`\bline (\d+)\b`)
+
+// errorOffset returns the byte offset of data that err points at, or -1.
+// Errors that only name a line point at its start and are not exact.
+func errorOffset(err error, data []byte) (offset int64, exact bool) {
+	var (
+		offsetErr *offsetError
+		syntaxErr *json.SyntaxError
+		csvErr    *csv.ParseError
+	)
+	switch {
+	case errors.As(err, &offsetErr):
+		return offsetErr.offset, true
+	case errors.As(err, &syntaxErr):
+		return syntaxErrorOffset(data, syntaxErr), true
+	case errors.As(err, &csvErr):
+		return lineOffset(data, csvErr.Line) + int64(csvErr.Column) - 1, true
+	}
+	if m := lineNumber.FindStringSubmatch(err.Error()); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return lineOffset(data, n), false
+	}
+	return -1, false
+}
+
+// lineOffset returns the offset of the first byte of a 1-based line
+func lineOffset(data []byte, line int) int64 {
+	var offset int64
+	for ; line > 1; line-- {
+		i := bytes.IndexByte(data[offset:], '\n')
+		if i < 0 {
+			break
+		}
+		offset += int64(i) + 1
+	}
+	return offset
+}
+
+// locate reads r up to a few lines past offset and returns the 1-based line
+// and column of offset, counting columns in characters, together with the
+// lines around it. Lines are cut to excerptWidth bytes; on the line of the
+// error, those are the bytes around the offset.
+func locate(r io.Reader, offset int64) (line, column int, excerpt []excerptLine) {
+	br := bufio.NewReader(r)
+	line, column = 1, 1
+
+	// Lines before the error keep their start, the current line its end
+	var head, tail []byte
+	long := false
+	for pos := int64(0); pos < offset; pos++ {
+		c, err := br.ReadByte()
+		if err != nil {
+			break
+		}
+		if c == '\n' {
+			excerpt = append(excerpt, excerptLine{number: line, text: excerptText(head, false, long), mark: -1})
+			if len(excerpt) > excerptContext {
+				excerpt = excerpt[1:]
+			}
+			line, column = line+1, 1
+			head, tail, long = head[:0], tail[:0], false
+			continue
+		}
+		if c&0xC0 != 0x80 {
+			column++
+		}
+		if len(head) < excerptWidth {
+			head = append(head, c)
+		} else {
+			long = true
+		}
+		tail = append(tail, c)
+		if len(tail) > 2*excerptWidth {
+			tail = append(tail[:0], tail[len(tail)-excerptWidth/2:]...)
+		}
+	}
+
+	// The line of the error, from half a width before the offset to half
+	// a width after it
+	before, cut := tail, false
+	if len(before) > excerptWidth/2 {
+		before, cut = before[len(before)-excerptWidth/2:], true
+		for len(before) > 0 && before[0]&0xC0 == 0x80 {
+			before = before[1:]
+		}
+	}
+	rest, eof := readLine(br, excerptWidth/2)
+	// The mark is counted after sanitizing, which may shorten the text
+	start := excerptText(before, cut, false)
+	excerpt = append(excerpt, excerptLine{number: line, text: start + excerptText(rest.text, false, rest.long), mark: len(start), failed: true})
+
+	for n := line + 1; n <= line+excerptContext && !eof; n++ {
+		var l excerptRead
+		l, eof = readLine(br, excerptWidth)
+		if eof && len(l.text) == 0 {
+			break
+		}
+		excerpt = append(excerpt, excerptLine{number: n, text: excerptText(l.text, false, l.long), mark: -1})
+	}
+	return line, column, excerpt
+}
+
+// excerptRead is the start of a line read for an excerpt
+type excerptRead struct {
+	text []byte
+	long bool // the line had more bytes than text
+}
+
+// readLine reads the rest of the current line, keeping up to width bytes of
+// it, and reports whether the input ended
+func readLine(br *bufio.Reader, width int) (excerptRead, bool) {
+	var l excerptRead
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return l, true
+		}
+		if c == '\n' {
+			return l, false
+		}
+		if len(l.text) < width {
+			l.text = append(l.text, c)
+		} else {
+			l.long = true
+		}
+	}
+}
+
+// excerptText prepares a line for display, marking where it was cut. Tabs
+// become spaces so a mark below the line stays in place, and a character
+// split by cutting the end is replaced.
+func excerptText(text []byte, cutStart, cutEnd bool) string {
+	s := strings.ToValidUTF8(strings.ReplaceAll(string(text), "\t", " "), "?")
+	if cutStart {
+		s = "…" + s
+	}
+	if cutEnd {
+		s += "…"
+	}
+	return s
+}
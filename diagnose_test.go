@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseErrorLocation(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		format string
+		line   int
+		column int
+		marked string // excerpt line of the error, with the marked character in brackets
+	}{
+		{"Bad literal", "{\n  \"a\": 1,\n  \"b\": tru\n}", "JSON", 3, 11, `  "b": tru[]`},
+		{"Missing comma", "[1, 2 3]", "JSON", 1, 7, `[1, 2 [3]]`},
+		{"Truncated", "{\"a\": [1,\n  2", "JSON", 2, 4, `  2[]`},
+		{"Multibyte", "{\"é\": é}", "JSON", 1, 7, `{"é": [é]}`},
+		{"Invalid UTF-8", "[\"\xe9\xe9\xe9\"", "JSON", 1, 7, `["?"[]`},
+		{"Stray bracket", "{\"a\": 1}}", "JSON", 1, 9, `{"a": 1}[}]`},
+		// Got further as JSONC, so that is the error worth showing
+		{"JSONC", "{\n  // note\n  \"a\": nope\n}", "JSONC", 3, 8, `  "a": [n]ope`},
+		{"YAML", "a: 1\nb: 2\n  c: 3\n", "YAML", 3, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseDocument([]byte(tt.input), loadOptions{})
+			e := asParseError(err)
+			if e == nil {
+				t.Fatalf("Expected a parse error, got %v", err)
+			}
+			if e.format != tt.format || e.line != tt.line || e.column != tt.column {
+				t.Errorf("Expected %s error at %d:%d, got %s at %d:%d (%v)", tt.format, tt.line, tt.column, e.format, e.line, e.column, e.err)
+			}
+			if got := markedLine(e); got != tt.marked {
+				t.Errorf("Expected excerpt %q, got %q", tt.marked, got)
+			}
+		})
+	}
+}
+
+// markedLine returns the excerpt line of the error with brackets around the
+// character it marks
+func markedLine(e *parseError) string {
+	for _, l := range e.excerpt {
+		if l.failed && l.mark >= 0 {
+			_, size := utf8.DecodeRuneInString(l.text[l.mark:])
+			end := l.mark + size
+			return l.text[:l.mark] + "[" + l.text[l.mark:end] + "]" + l.text[end:]
+		}
+	}
+	return ""
+}
+
+func TestParseEmptyInput(t *testing.T) {
+	// A failed download is not reported as broken JSONC at line 1
+	for _, input := range []string{"", " \n\t\n"} {
+		_, err := parseDocument([]byte(input), loadOptions{})
+		if err == nil || asParseError(err) != nil || !strings.Contains(err.Error(), "empty") {
+			t.Errorf("Expected %q to be reported as empty, got %v", input, err)
+		}
+	}
+}
+
+func TestLocateLongLines(t *testing.T) {
+	before := strings.Repeat("a", 200)
+	input := "first\n" + strings.Repeat("b", 300) + "\n" + before + "X" + strings.Repeat("c", 200) + "\nlast\nnot shown\nnot shown"
+	offset := int64(strings.Index(input, "X"))
+
+	line, column, excerpt := locate(strings.NewReader(input), offset)
+	if line != 3 || column != 201 {
+		t.Errorf("Expected 3:201, got %d:%d", line, column)
+	}
+
+	var numbers []string
+	for _, l := range excerpt {
+		numbers = append(numbers, fmt.Sprint(l.number))
+		if len(l.text) > excerptWidth+2*len("…") {
+			t.Errorf("Line %d is not cut: %d bytes", l.number, len(l.text))
+		}
+	}
+	if got := strings.Join(numbers, ","); got != "1,2,3,4,5" {
+		t.Errorf("Expected lines 1-5, got %s", got)
+	}
+
+	errLine := excerpt[2]
+	if !errLine.failed || errLine.text[errLine.mark] != 'X' {
+		t.Errorf("Expected the mark on X, got %q at %d", errLine.text, errLine.mark)
+	}
+	if !strings.HasPrefix(errLine.text, "…a") || !strings.HasSuffix(errLine.text, "c…") {
+		t.Errorf("Expected the error line cut on both sides, got %q", errLine.text)
+	}
+}
+
+func TestDecodeJSONPrefix(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"a": 1, "b": [1, 2, {"c": tr`, `{"a":1,"b":[1,2,{}]}`},
+		{"{\"a\": 1}\n{\"a\": 2}\n{\"a\": [3", `[{"a":1},{"a":2},{"a":[3]}]`},
+		{`{"a": 1, "a": [2`, `{"a":[2]}`},
+		{`[1, 2,, 3]`, `[1,2]`},
+		{`{"a": x}`, ``},
+		{`nope`, ``},
+	}
+
+	for _, tt := range tests {
+		value, ok := decodeJSONPrefix([]byte(tt.input))
+		got := ""
+		if ok {
+			got, _ = buildJSONTree(value, nil, "").compactJSON()
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
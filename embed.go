@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// embedding is a kind of value found inside strings, decoded into parts that
+// are shown as the string's children
+type embedding struct {
+	kind  string // named next to the string, as "JWT"
+	parts []embeddedPart
+}
+
+// embeddedPart is one value decoded from a string
+type embeddedPart struct {
+	label  string // shown in place of a key, as "| fromjson"
+	filter string // jq filter that decodes it from the string
+	decode func(s string) (interface{}, error)
+}
+
+var (
+	jsonEmbedding = &embedding{
+		kind:  "JSON",
+		parts: []embeddedPart{{label: "| fromjson", filter: "fromjson", decode: decodeJSONString}},
+	}
+	base64Embedding = &embedding{
+		kind:  "base64",
+		parts: []embeddedPart{{label: "| @base64d", filter: "@base64d", decode: decodeBase64Text}},
+	}
+	// jq's @base64d only reads the standard alphabet, so tokens using the
+	// URL-safe characters are translated first
+	jwtEmbedding = &embedding{
+		kind: "JWT",
+		parts: []embeddedPart{
+			{label: "header", filter: `split(".")[0] | @base64d | fromjson`, decode: jwtSegment(0)},
+			{label: "claims", filter: `split(".")[1] | @base64d | fromjson`, decode: jwtSegment(1)},
+		},
+	}
+	jwtURLEmbedding = &embedding{
+		kind: "JWT",
+		parts: []embeddedPart{
+			{label: "header", filter: `split(".")[0] | gsub("-"; "+") | gsub("_"; "/") | @base64d | fromjson`, decode: jwtSegment(0)},
+			{label: "claims", filter: `split(".")[1] | gsub("-"; "+") | gsub("_"; "/") | @base64d | fromjson`, decode: jwtSegment(1)},
+		},
+	}
+)
+
+const (
+	minBase64Len = 8 // shorter strings are too often plain words
+	maxEmbedLen  = 1 << 20
+)
+
+// jwtDateClaims are the registered JWT claims holding seconds since the epoch
+var jwtDateClaims = map[string]bool{"exp": true, "iat": true, "nbf": true, "auth_time": true}
+
+// detectEmbedding returns what is embedded in s, or nil for an ordinary
+// string. Serialized JSON only counts when it is an object or array, so a
+// string like "42" stays a string, and base64 only when it decodes to text.
+func detectEmbedding(s string) *embedding {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" || len(trimmed) > maxEmbedLen {
+		return nil
+	}
+	switch {
+	case trimmed[0] == '{' || trimmed[0] == '[':
+		if json.Valid([]byte(trimmed)) {
+			return jsonEmbedding
+		}
+	case strings.Count(s, ".") == 2:
+		if _, err := jwtSegment(0)(s); err != nil {
+			return nil
+		}
+		if _, err := jwtSegment(1)(s); err != nil {
+			return nil
+		}
+		if strings.ContainsAny(s, "-_") {
+			return jwtURLEmbedding
+		}
+		return jwtEmbedding
+	case len(s) >= minBase64Len && len(s)%4 == 0:
+		if _, err := decodeBase64Text(s); err == nil {
+			return base64Embedding
+		}
+	}
+	return nil
+}
+
+func decodeJSONString(s string) (interface{}, error) {
+	return decodeJSON([]byte(strings.TrimSpace(s)))
+}
+
+// decodeBase64Text decodes standard, padded base64 that holds printable text
+func decodeBase64Text(s string) (interface{}, error) {
+	data, err := base64.StdEncoding.Strict().DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("base64 data is not text")
+	}
+	text := string(data)
+	for _, r := range text {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return nil, fmt.Errorf("base64 data is not text")
+		}
+	}
+	return text, nil
+}
+
+// jwtSegment decodes segment i of a JSON Web Token, which must be a JSON
+// object in unpadded base64url
+func jwtSegment(i int) func(s string) (interface{}, error) {
+	return func(s string) (interface{}, error) {
+		segments := strings.Split(s, ".")
+		if len(segments) != 3 {
+			return nil, fmt.Errorf("a JWT has 3 segments")
+		}
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segments[i], "="))
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := value.(orderedObject); !ok {
+			return nil, fmt.Errorf("JWT segment %d is not an object", i)
+		}
+		return value, nil
+	}
+}
+
+// loadEmbedded decodes the values embedded in a string node into its
+// children, which are shown under the string when it is expanded
+func (n *JSONNode) loadEmbedded() error {
+	if len(n.Children) > 0 {
+		return nil
+	}
+	str, _ := n.Value.(string)
+	children := make([]*JSONNode, len(n.Embed.parts))
+	for i, part := range n.Embed.parts {
+		value, err := part.decode(str)
+		if err != nil {
+			return err
+		}
+		children[i] = buildJSONTree(value, n, part.label)
+		children[i].Index = i
+	}
+	n.Children = children
+	return nil
+}
+
+// expandable reports whether n has children to show: a container, or a
+// string with a value embedded in it
+func (n *JSONNode) expandable() bool {
+	return n.isContainer() || n.Embed != nil
+}
+
+// decoded reports whether n is a value decoded from the string above it
+func (n *JSONNode) decoded() bool {
+	return n.Parent != nil && n.Parent.Embed != nil
+}
+
+// decodeFilter returns the jq filter that decodes n from the string above it
+func (n *JSONNode) decodeFilter() string {
+	return n.Parent.Embed.parts[n.Index].filter
+}
+
+// dateNote renders the JWT timestamp claims as dates
+func (n *JSONNode) dateNote() string {
+	claims := n.Parent
+	if n.Type != "number" || claims == nil || !claims.decoded() || claims.Parent.Embed.kind != "JWT" || claims.Key != "claims" || !jwtDateClaims[n.Key] {
+		return ""
+	}
+	num, ok := n.Value.(json.Number)
+	if !ok {
+		return ""
+	}
+	seconds, err := num.Int64()
+	if err != nil {
+		return ""
+	}
+	return time.Unix(seconds, 0).UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+// decodesString reports whether the query being built reads a value
+// decoded from a string, which only jq can express
+func (m model) decodesString(node *JSONNode) bool {
+	paths := m.markedPaths()
+	if node != nil {
+		paths = append(paths, node.path())
+	}
+	for _, segs := range paths {
+		for _, seg := range segs {
+			if seg.Decode != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
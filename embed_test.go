@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedJSON(t *testing.T) {
+	root := mustBuildTree(t, `{"payload": "{\"id\": 1, \"tags\": [\"a\"], \"inner\": \"[2, 3]\"}", "count": "42", "note": "{not json}"}`)
+	payload, count, note := root.Children[0], root.Children[1], root.Children[2]
+	if payload.Embed != jsonEmbedding || payload.Expanded || len(payload.Children) != 0 {
+		t.Fatalf("Expected payload to be a collapsed JSON string, got %v", payload.Embed)
+	}
+	if count.expandable() || note.expandable() {
+		t.Errorf("Expected scalars and invalid JSON to stay plain strings")
+	}
+
+	if err := payload.setExpanded(true); err != nil {
+		t.Fatal(err)
+	}
+	decoded := payload.Children[0]
+	if decoded.Type != "object" || decoded.getDisplayName() != "| fromjson" || decoded.buildJqQuery() != ".payload | fromjson" {
+		t.Fatalf("Unexpected decoded value %s %q at %s", decoded.Type, decoded.getDisplayName(), decoded.buildJqQuery())
+	}
+
+	id := decoded.Children[0]
+	tag := decoded.Children[1].Children[0]
+	inner := decoded.Children[2]
+	if err := inner.setExpanded(true); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		node     *JSONNode
+		expected string
+	}{
+		{id, ".payload | fromjson | .id"},
+		{tag, ".payload | fromjson | .tags[0]"},
+		{inner.Children[0].Children[1], ".payload | fromjson | .inner | fromjson | .[1]"},
+	}
+	for _, tt := range tests {
+		if got := tt.node.buildJqQuery(); got != tt.expected {
+			t.Errorf("Expected %s, got %s", tt.expected, got)
+		}
+		data, _ := parseJSON([]byte(`{"payload": "{\"id\": 1, \"tags\": [\"a\"], \"inner\": \"[2, 3]\"}"}`))
+		result, err := evalFilter(tt.expected, data)
+		if err != nil || result.Value != tt.node.Value {
+			t.Errorf("Expected jq to read %v with %s, got %v (%v)", tt.node.Value, tt.expected, result, err)
+		}
+	}
+
+	// The whole input may be a JSON string
+	if got := mustBuildTree(t, `"{\"a\": 1}"`).Embed; got != jsonEmbedding {
+		t.Errorf("Expected a top-level JSON string to be expandable, got %v", got)
+	}
+}
+
+func TestEmbeddedJSONQueries(t *testing.T) {
+	root := mustBuildTree(t, `{"events": [{"body": "{\"id\": 1, \"ok\": true}"}, {"body": "{\"id\": 2, \"ok\": false}"}]}`)
+	body := root.Children[0].Children[0].Children[0]
+	if err := body.setExpanded(true); err != nil {
+		t.Fatal(err)
+	}
+	id, ok := body.Children[0].Children[0], body.Children[0].Children[1]
+	m := model{root: root}
+
+	m.selectNode(id)
+	m.cycleWildcard()
+	if m.query != ".events[].body | fromjson | .id" || m.queryNote != "Yields 2 values" {
+		t.Errorf("Unexpected wildcard %s (%s)", m.query, m.queryNote)
+	}
+
+	m.startPredicate(ok)
+	if m.query != ".events[] | select(.body | fromjson | .ok == true)" {
+		t.Errorf("Unexpected predicate %s", m.query)
+	}
+
+	m.selectNode(nil)
+	m.toggleMark(id)
+	m.toggleMark(ok)
+	if m.query != ".events[] | {id: .body | fromjson | .id, ok: .body | fromjson | .ok}" {
+		t.Errorf("Unexpected projection %s", m.query)
+	}
+
+	// Other path syntaxes cannot decode a string
+	m.format = 1
+	m.refreshQuery()
+	if m.query != "" || !strings.Contains(m.queryErr, "decoded from a string") {
+		t.Errorf("Expected JSONPath to be unsupported, got %q (%s)", m.query, m.queryErr)
+	}
+}
+
+func TestEmbeddedBase64AndJWT(t *testing.T) {
+	segment := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	token := segment(`{"alg":"HS256","typ":"JWT"}`) + "." + segment(`{"sub":"42","exp":1700000000,"iat":1699990000}`) + ".c2lnbmF0dXJl"
+	input := `{"token": "` + token + `", "secret": "cGFzc3dvcmQ=", "body": "` + base64.StdEncoding.EncodeToString([]byte(`{"id": 5}`)) + `"}`
+	root := mustBuildTree(t, input)
+	data, _ := parseJSON([]byte(input))
+
+	jwt := root.Children[0]
+	if jwt.Embed == nil || jwt.Embed.kind != "JWT" {
+		t.Fatalf("Expected the token to be detected as a JWT, got %v", jwt.Embed)
+	}
+	if err := jwt.setExpanded(true); err != nil {
+		t.Fatal(err)
+	}
+	header, claims := jwt.Children[0], jwt.Children[1]
+	if header.Key != "header" || header.Children[0].Value != "HS256" || claims.Key != "claims" {
+		t.Fatalf("Unexpected JWT parts %s and %s", header.Key, claims.Key)
+	}
+	if got := claims.Children[1].dateNote(); got != "2023-11-14 22:13:20 UTC" {
+		t.Errorf("Expected exp as a date, got %q", got)
+	}
+	if got := claims.Children[0].dateNote(); got != "" {
+		t.Errorf("Expected no date for sub, got %q", got)
+	}
+
+	secret, body := root.Children[1], root.Children[2]
+	for _, node := range []*JSONNode{secret, body} {
+		if node.Embed != base64Embedding {
+			t.Fatalf("Expected %s to be detected as base64, got %v", node.Key, node.Embed)
+		}
+		if err := node.setExpanded(true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decodedBody := body.Children[0]
+	if err := decodedBody.setExpanded(true); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		node     *JSONNode
+		expected string
+	}{
+		{claims.Children[0], `.token | ` + jwt.Embed.parts[1].filter + ` | .sub`},
+		{secret.Children[0], `.secret | @base64d`},
+		{decodedBody.Children[0].Children[0], `.body | @base64d | fromjson | .id`},
+	}
+	for _, tt := range tests {
+		if got := tt.node.buildJqQuery(); got != tt.expected {
+			t.Errorf("Expected %s, got %s", tt.expected, got)
+		}
+		result, err := evalFilter(tt.expected, data)
+		if err != nil || result.Value != tt.node.Value {
+			t.Errorf("Expected jq to read %v with %s, got %v (%v)", tt.node.Value, tt.expected, result, err)
+		}
+	}
+	if !strings.HasPrefix(tests[0].expected, `.token | split(".")[1] |`) {
+		t.Errorf("Expected the claims to be read from the second segment, got %s", tests[0].expected)
+	}
+
+	// Strings that merely could be base64 stay strings
+	for _, s := range []string{"username", "12345678", "abcdefgh", "2024-01-01", "v1.2.3", "a.b.c", "AAAAAAAA"} {
+		if e := detectEmbedding(s); e != nil {
+			t.Errorf("Expected %q to be a plain string, got %s", s, e.kind)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/itchyny/gojq"
+)
+
+const (
+	// evalTimeout bounds a single filter evaluation so runaway filters such
+	// as `repeat(.)` cannot hang the UI
+	evalTimeout = 2 * time.Second
+	// maxEvalResults caps how many outputs of a filter are collected
+	maxEvalResults = 10000
+)
+
+// filterResultMsg carries the outcome of evaluating the filter bar. Seq
+// identifies the keystroke that started it so stale results are dropped.
+type filterResultMsg struct {
+	seq  int
+	root *JSONNode
+	err  error
+}
+
+// evalFilterCmd evaluates filter against the decoded document in the
+// background
+func evalFilterCmd(seq int, filter string, doc interface{}) tea.Cmd {
+	return func() tea.Msg {
+		root, err := evalFilter(filter, doc)
+		return filterResultMsg{seq: seq, root: root, err: err}
+	}
+}
+
+// evalFilter runs a jq filter on doc with the embedded gojq engine and
+// returns its output as a tree. A single output is the root; several outputs
+// are shown as an array, one element per output.
+func evalFilter(filter string, doc interface{}) (*JSONNode, error) {
+	query, err := gojq.Parse(filter)
+	if err != nil {
+		return nil, err
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), evalTimeout)
+	defer cancel()
+
+	// gojq normalizes its input in place, so every run gets its own copy
+	var results []interface{}
+	iter := code.RunWithContext(ctx, toGojqValue(doc))
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, isErr := v.(error); isErr {
+			if haltErr, isHalt := err.(*gojq.HaltError); isHalt && haltErr.Value() == nil {
+				break
+			}
+			return nil, err
+		}
+		if len(results) == maxEvalResults {
+			return nil, fmt.Errorf("more than %d results", maxEvalResults)
+		}
+		results = append(results, fromGojqValue(v))
+	}
+
+	switch len(results) {
+	case 0:
+		return nil, nil
+	case 1:
+		return buildJSONTree(results[0], nil, ""), nil
+	default:
+		return buildJSONTree(results, nil, ""), nil
+	}
+}
+
+// toGojqValue copies a decoded document into the plain maps and slices gojq
+// works on. json.Number literals are accepted by gojq as they are.
+func toGojqValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case orderedObject:
+		obj := make(map[string]interface{}, len(v))
+		for _, field := range v {
+			obj[field.Key] = toGojqValue(field.Value)
+		}
+		return obj
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, elem := range v {
+			arr[i] = toGojqValue(elem)
+		}
+		return arr
+	default:
+		return v
+	}
+}
+
+// fromGojqValue copies a gojq output, turning numbers back into json.Number
+// so results render like input values
+func fromGojqValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			obj[k] = fromGojqValue(elem)
+		}
+		return obj
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, elem := range v {
+			arr[i] = fromGojqValue(elem)
+		}
+		return arr
+	case int:
+		return json.Number(strconv.Itoa(v))
+	case *big.Int:
+		return json.Number(v.String())
+	case float64:
+		// Like jq, print NaN as null and clamp infinities
+		switch {
+		case math.IsNaN(v):
+			return nil
+		case math.IsInf(v, 1):
+			v = math.MaxFloat64
+		case math.IsInf(v, -1):
+			v = -math.MaxFloat64
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64))
+	default:
+		return v
+	}
+}
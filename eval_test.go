@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvalFilter(t *testing.T) {
+	data, err := parseJSON([]byte(`{"users": [{"id": 1234567890123456789, "name": "John"}, {"id": 2, "name": "Jane"}], "ratio": 0.5}`))
+	if err != nil {
+		t.Fatalf("parseJSON: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		filter   string
+		expected string
+		err      string
+	}{
+		{"Identity path", ".users[1].name", `"Jane"`, ""},
+		{"Big integers stay exact", ".users[0].id", "1234567890123456789", ""},
+		{"Arithmetic", ".ratio * 3", "1.5", ""},
+		{"Multiple outputs become an array", ".users[].name", `["John","Jane"]`, ""},
+		{"Object construction", ".users[1] | {name}", `{"name":"Jane"}`, ""},
+		{"No output", "empty", "", ""},
+		{"Parse error", ".users[", "", "unexpected EOF"},
+		{"Runtime error", ".users | keys | .[0] | ascii_downcase", "", "cannot"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := evalFilter(tt.filter, data)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("evalFilter: %v", err)
+			}
+			if tt.expected == "" {
+				if root != nil {
+					t.Errorf("Expected no results, got a tree")
+				}
+				return
+			}
+			got, err := root.compactJSON()
+			if err != nil {
+				t.Fatalf("compactJSON: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	// Evaluation must not modify the document it runs on
+	if out, _ := buildJSONTree(data, nil, "").compactJSON(); !strings.Contains(out, "1234567890123456789") {
+		t.Errorf("Document was modified: %s", out)
+	}
+}
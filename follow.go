@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	followInterval = 250 * time.Millisecond // how often a followed file is checked for new data
+	followBatch    = 1000                   // most records delivered in one message
+)
+
+// followRecord is a line read from a followed input
+type followRecord struct {
+	value interface{}
+	line  int
+	err   error // the line is not valid JSON
+}
+
+// followMsg delivers the records read from a followed input since the last
+// message
+type followMsg struct {
+	records []followRecord
+	ended   bool  // the input was closed, or reading it failed
+	err     error // why reading failed
+	next    tea.Cmd
+}
+
+// follower reads a growing JSON Lines input in the background
+type follower struct {
+	records chan followRecord
+	err     error // set before records is closed
+}
+
+// startFollowing opens the file at path, or stdin, as an empty array that
+// the records of the input are appended to as they are written. The loaded
+// document is delivered with a command that waits for the first records.
+func startFollowing(path string) tea.Cmd {
+	return func() tea.Msg {
+		var (
+			r      io.Reader = os.Stdin
+			closer io.Closer
+		)
+		if path != "" && path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return loadDoneMsg{err: fmt.Errorf("reading %s: %v", path, err)}
+			}
+			r, closer = &tailReader{f: f}, f
+		}
+
+		f := &follower{records: make(chan followRecord, followBatch)}
+		go f.read(r)
+		doc := &document{root: buildJSONTree([]interface{}{}, nil, ""), closer: closer, source: sourceJSON, stream: true}
+		return loadDoneMsg{doc: doc, next: f.wait}
+	}
+}
+
+// read decodes r line by line until it ends. Lines that are not JSON are
+// passed on as errors so they can be counted like in a JSON Lines file.
+func (f *follower) read(r io.Reader) {
+	defer close(f.records)
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		text, err := br.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(text); len(trimmed) > 0 {
+			value, decodeErr := decodeJSON(trimmed)
+			f.records <- followRecord{value: value, line: line, err: decodeErr}
+		}
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			f.err = err
+			return
+		}
+	}
+}
+
+// wait blocks until records are available and delivers them together with
+// whatever else has been read by then
+func (f *follower) wait() tea.Msg {
+	var msg followMsg
+	record, ok := <-f.records
+	for ok {
+		msg.records = append(msg.records, record)
+		if len(msg.records) == followBatch {
+			break
+		}
+		select {
+		case record, ok = <-f.records:
+		default:
+			msg.next = f.wait
+			return msg
+		}
+	}
+	if ok {
+		msg.next = f.wait
+	} else {
+		msg.ended, msg.err = true, f.err
+	}
+	return msg
+}
+
+// appendRecords adds followed records to the root array, counting the lines
+// that are not JSON as skipped
+func (m *model) appendRecords(records []followRecord) {
+	root := m.doc.root
+	values, _ := root.Value.([]interface{})
+	for _, r := range records {
+		if r.err != nil {
+			m.doc.skipped = append(m.doc.skipped, lineError{line: r.line, err: r.err})
+			continue
+		}
+		child := buildJSONTree(r.value, root, strconv.Itoa(len(root.Children)))
+		child.Index = len(root.Children)
+		child.Line = r.line
+		if m.sortKeys {
+			child.sortChildren(true)
+		}
+		root.Children = append(root.Children, child)
+		values = append(values, r.value)
+	}
+	root.Value = values
+
+	// The jq filter runs on the document as it was decoded
+	m.filterDoc = nil
+	if m.searchTerm != "" {
+		m.updateFilteredNodes()
+	}
+	if m.autoScroll && !m.searchMode && m.searchTerm == "" {
+		m.cursor = len(m.root.getAllVisibleNodes()) - 1
+	}
+}
+
+// tailReader reads a file that is still being written: at the end of the
+// file it waits for more data instead of returning io.EOF, like tail -f
+type tailReader struct {
+	f   *os.File
+	pos int64
+}
+
+func (t *tailReader) Read(b []byte) (int, error) {
+	for {
+		n, err := t.f.Read(b)
+		t.pos += int64(n)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		// A file truncated in place, as by copytruncate log rotation, is
+		// read again from the start
+		if stat, err := t.f.Stat(); err != nil {
+			return 0, err
+		} else if stat.Size() < t.pos {
+			if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+				return 0, err
+			}
+			t.pos = 0
+			continue
+		}
+		time.Sleep(followInterval)
+	}
+}
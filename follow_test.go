@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// waitRecords collects followed records until want have arrived
+func waitRecords(t *testing.T, f *follower, want int) []followRecord {
+	t.Helper()
+	var records []followRecord
+	deadline := time.After(5 * time.Second)
+	for len(records) < want {
+		done := make(chan followMsg, 1)
+		go func() { done <- f.wait().(followMsg) }()
+		select {
+		case msg := <-done:
+			records = append(records, msg.records...)
+			if msg.ended && len(records) < want {
+				t.Fatalf("Input ended after %d of %d records", len(records), want)
+			}
+		case <-deadline:
+			t.Fatalf("Timed out after %d of %d records", len(records), want)
+		}
+	}
+	return records
+}
+
+func TestFollowStream(t *testing.T) {
+	r, w := io.Pipe()
+	f := &follower{records: make(chan followRecord, followBatch)}
+	go f.read(r)
+
+	w.Write([]byte("{\"n\": 1}\nnot json\n\n{\"n\":"))
+	records := waitRecords(t, f, 2)
+	if records[0].line != 1 || records[0].err != nil || records[1].line != 2 || records[1].err == nil {
+		t.Fatalf("Expected a record on line 1 and an error on line 2, got %+v", records)
+	}
+
+	// The rest of a line arrives later, and the input ends without a newline
+	w.Write([]byte(" 2}\n{\"n\": 3}"))
+	w.Close()
+	records = waitRecords(t, f, 2)
+	if records[0].line != 4 || records[1].line != 5 {
+		t.Errorf("Expected records on lines 4 and 5, got %+v", records)
+	}
+	if msg := f.wait().(followMsg); !msg.ended || msg.err != nil || msg.next != nil {
+		t.Errorf("Expected the stream to end cleanly, got %+v", msg)
+	}
+}
+
+func TestFollowFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log.ndjson")
+	if err := os.WriteFile(path, []byte("{\"n\": 1}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	f := &follower{records: make(chan followRecord, followBatch)}
+	go f.read(&tailReader{f: file})
+	waitRecords(t, f, 1)
+
+	out, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Write([]byte("{\"n\": 2}\n"))
+	out.Close()
+	if records := waitRecords(t, f, 1); records[0].line != 2 {
+		t.Errorf("Expected the appended record on line 2, got %+v", records)
+	}
+
+	// Truncating the file starts over from its beginning
+	if err := os.WriteFile(path, []byte("{\"n\": 3}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if records := waitRecords(t, f, 1); records[0].value.(orderedObject)[0].Value != json.Number("3") {
+		t.Errorf("Expected the rewritten record, got %+v", records)
+	}
+}
+
+func TestAppendRecords(t *testing.T) {
+	doc := &document{root: buildJSONTree([]interface{}{}, nil, ""), source: sourceJSON}
+	m := model{doc: doc, root: doc.root, follow: true, autoScroll: true}
+
+	m.appendRecords([]followRecord{
+		{value: orderedObject{{Key: "b", Value: "1"}, {Key: "a", Value: "2"}}, line: 1},
+		{line: 2, err: io.ErrUnexpectedEOF},
+		{value: []interface{}{"x"}, line: 3},
+	})
+
+	if len(doc.root.Children) != 2 || len(doc.skipped) != 1 || doc.skipped[0].line != 2 {
+		t.Fatalf("Expected 2 records and 1 skipped line, got %d and %v", len(doc.root.Children), doc.skipped)
+	}
+	last := doc.root.Children[1]
+	if last.Line != 3 || last.Index != 1 || last.buildJqQuery() != ".[1]" {
+		t.Errorf("Unexpected second record: line %d, index %d, %s", last.Line, last.Index, last.buildJqQuery())
+	}
+	if visible := doc.root.getAllVisibleNodes(); m.cursor != len(visible)-1 {
+		t.Errorf("Expected auto-scroll to the last node, cursor at %d of %d", m.cursor, len(visible))
+	}
+	value, err := doc.root.value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := value.([]interface{}); len(values) != 2 {
+		t.Errorf("Expected the jq document to hold 2 records, got %d", len(values))
+	}
+}
+
+// update feeds msg to m and then the messages of the commands it returns,
+// as the program would
+func update(m model, msg tea.Msg) model {
+	updated, cmd := m.Update(msg)
+	m = updated.(model)
+	if cmd == nil {
+		return m
+	}
+	result := cmd()
+	if batch, ok := result.(tea.BatchMsg); ok {
+		for _, c := range batch {
+			m = update(m, c())
+		}
+		return m
+	}
+	return update(m, result)
+}
+
+func TestFilterFollowedRecords(t *testing.T) {
+	doc := &document{root: buildJSONTree([]interface{}{}, nil, ""), source: sourceJSON}
+	m := model{doc: doc, root: doc.root, follow: true, filterText: "map(.n)"}
+
+	for _, n := range []string{"1", "2"} {
+		m = update(m, followMsg{records: []followRecord{{value: orderedObject{{Key: "n", Value: json.Number(n)}}, line: 1}}})
+	}
+	if m.filterErr != "" || m.filterRoot == nil || len(m.filterRoot.Children) != 2 {
+		t.Fatalf("Expected the filter to see both records, got %v (%s)", m.filterRoot, m.filterErr)
+	}
+	if last := m.filterRoot.Children[1]; last.Value != json.Number("2") {
+		t.Errorf("Expected the last record to be 2, got %v", last.Value)
+	}
+}
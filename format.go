@@ -0,0 +1,459 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathFormat renders the queries jqpick builds in one path syntax. Renderers
+// left nil mark kinds of query the syntax cannot express.
+type pathFormat struct {
+	Name string
+	Path func(segs []pathSegment) (string, error)
+	// Wildcard renders a generalized path in one of WildcardForms ways
+	Wildcard      func(segs []pathSegment, form int) (string, error)
+	WildcardForms int
+	Projection    func(base []pathSegment, fields []projectionField) (string, error)
+	Predicate     func(p predicate) (string, error)
+	// Usage shows where a query in this format is pasted, if not into jq
+	Usage func(query string) string
+	// Decodes is set when paths may decode values embedded in strings
+	Decodes bool
+}
+
+// pathFormats are the formats the query section cycles through, jq first
+var pathFormats = []pathFormat{jqFormat, jsonPathFormat, jsonPointerFormat, jmesPathFormat}
+
+var jqFormat = pathFormat{
+	Name: "jq",
+	Path: func(segs []pathSegment) (string, error) {
+		return formatJqPath(segs), nil
+	},
+	Wildcard: func(segs []pathSegment, form int) (string, error) {
+		return formatJqWildcard(segs, form), nil
+	},
+	WildcardForms: wildcardForms,
+	Projection: func(base []pathSegment, fields []projectionField) (string, error) {
+		return formatJqProjection(base, fields), nil
+	},
+	Predicate: func(p predicate) (string, error) {
+		return formatJqPredicate(p), nil
+	},
+	Decodes: true,
+}
+
+var jsonPathFormat = pathFormat{
+	Name: "JSONPath",
+	Path: func(segs []pathSegment) (string, error) {
+		return formatJSONPath("$", segs), nil
+	},
+	Wildcard: func(segs []pathSegment, form int) (string, error) {
+		return formatJSONPath("$", segs), nil
+	},
+	WildcardForms: 1,
+	Predicate:     formatJSONPathPredicate,
+}
+
+var jsonPointerFormat = pathFormat{
+	Name: "JSON Pointer",
+	Path: formatJSONPointer,
+}
+
+// codeFormats render paths as accessors in source code and other tools. They
+// are offered by the copy menu next to pathFormats.
+var codeFormats = []pathFormat{pythonFormat, javaScriptFormat, gjsonFormat, yqFormat}
+
+// unsupported is the error shown in place of a query f cannot express
+func (f pathFormat) unsupported(what string) error {
+	return fmt.Errorf("%s cannot express %s", f.Name, what)
+}
+
+// formatJSONPath renders segments as a JSONPath expression starting at root,
+// which is $ for the document and @ for the current element in a filter
+func formatJSONPath(root string, segs []pathSegment) string {
+	var b strings.Builder
+	b.WriteString(root)
+	for _, seg := range segs {
+		switch {
+		case seg.Iterate:
+			b.WriteString("[*]")
+		case seg.IsIndex:
+			b.WriteString("[" + strconv.Itoa(seg.Index) + "]")
+		case isPlainIdentifier(seg.Key):
+			b.WriteString("." + seg.Key)
+		default:
+			b.WriteString("[" + quoteJSONPathString(seg.Key) + "]")
+		}
+	}
+	return b.String()
+}
+
+// formatJSONPathPredicate renders a predicate as a filter selector,
+// $.users[?(@.active == false)]. JSONPath implementations disagree on
+// substring and regex matching, so only comparisons are rendered.
+func formatJSONPathPredicate(p predicate) (string, error) {
+	switch p.Op {
+	case "==", "!=", "<", ">":
+	default:
+		return "", fmt.Errorf("JSONPath has no portable %s operator; press o for a comparison", p.Op)
+	}
+	if len(p.Base) == 0 {
+		return "", fmt.Errorf("JSONPath filters select array elements; the value is not inside an array")
+	}
+
+	array := formatJSONPath("$", p.Base[:len(p.Base)-1])
+	cond := formatJSONPath("@", p.Field) + " " + p.Op + " " + p.Literal
+	return array + "[?(" + cond + ")]", nil
+}
+
+// isPlainIdentifier reports whether key is made of letters, digits and
+// underscores and does not start with a digit. Such keys need no quoting in
+// JSONPath or JMESPath; the check is stricter than RFC 9535 so older JSONPath
+// implementations accept the result too.
+func isPlainIdentifier(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, r := range key {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// quoteJSONPathString returns s as a single-quoted JSONPath name selector
+func quoteJSONPathString(s string) string {
+	quoted := quoteJSONString(s)
+	body := quoted[1 : len(quoted)-1]
+	body = strings.ReplaceAll(body, `\"`, `"`)
+	body = strings.ReplaceAll(body, `'`, `\'`)
+	return "'" + body + "'"
+}
+
+// formatJSONPointer renders segments as an RFC 6901 JSON Pointer. The whole
+// document is the empty pointer.
+func formatJSONPointer(segs []pathSegment) (string, error) {
+	var b strings.Builder
+	for _, seg := range segs {
+		switch {
+		case seg.Iterate:
+			return "", fmt.Errorf("JSON Pointer addresses a single value and has no wildcard")
+		case seg.IsIndex:
+			b.WriteString("/" + strconv.Itoa(seg.Index))
+		default:
+			key := strings.ReplaceAll(seg.Key, "~", "~0")
+			b.WriteString("/" + strings.ReplaceAll(key, "/", "~1"))
+		}
+	}
+	return b.String(), nil
+}
+
+var jmesPathFormat = pathFormat{
+	Name: "JMESPath",
+	Path: func(segs []pathSegment) (string, error) {
+		return formatJMESPath(segs), nil
+	},
+	Wildcard: func(segs []pathSegment, form int) (string, error) {
+		return formatJMESPath(segs), nil
+	},
+	WildcardForms: 1,
+	Projection: func(base []pathSegment, fields []projectionField) (string, error) {
+		return formatJMESPathProjection(base, fields), nil
+	},
+	Predicate: formatJMESPathPredicate,
+	Usage: func(query string) string {
+		return "AWS CLI: aws ... --query " + singleQuote(query)
+	},
+}
+
+// formatJMESPath renders segments as a JMESPath expression. The whole
+// document is the current node, @. Iterators after the first flatten, so
+// nested arrays yield one list like jq rather than a list of lists.
+func formatJMESPath(segs []pathSegment) string {
+	if len(segs) == 0 {
+		return "@"
+	}
+
+	var b strings.Builder
+	iterated := false
+	for i, seg := range segs {
+		switch {
+		case seg.Iterate && iterated:
+			b.WriteString("[]")
+		case seg.Iterate:
+			b.WriteString("[*]")
+			iterated = true
+		case seg.IsIndex:
+			b.WriteString("[" + strconv.Itoa(seg.Index) + "]")
+		default:
+			if i > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(jmesPathIdentifier(seg.Key))
+		}
+	}
+	return b.String()
+}
+
+// formatJMESPathProjection renders base.{name: field, ...}
+func formatJMESPathProjection(base []pathSegment, fields []projectionField) string {
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = jmesPathIdentifier(field.Name) + ": " + formatJMESPath(field.Path)
+	}
+
+	hash := "{" + strings.Join(parts, ", ") + "}"
+	if len(base) == 0 {
+		return hash
+	}
+	return formatJMESPath(base) + "." + hash
+}
+
+// formatJMESPathPredicate renders a predicate as a filter expression,
+// users[?active == `false`]. JMESPath has no regular expressions.
+func formatJMESPathPredicate(p predicate) (string, error) {
+	if len(p.Base) == 0 {
+		return "", fmt.Errorf("JMESPath filters select array elements; the value is not inside an array")
+	}
+
+	field := "@"
+	if len(p.Field) > 0 {
+		field = formatJMESPath(p.Field)
+	}
+	literal := "`" + strings.ReplaceAll(p.Literal, "`", "\\`") + "`"
+
+	var cond string
+	switch p.Op {
+	case "contains":
+		cond = "contains(" + field + ", " + literal + ")"
+	case "test":
+		return "", fmt.Errorf("JMESPath has no regular expressions; press o for another operator")
+	default:
+		cond = field + " " + p.Op + " " + literal
+	}
+
+	var array string
+	if len(p.Base) > 1 {
+		array = formatJMESPath(p.Base[:len(p.Base)-1])
+	}
+	return array + "[?" + cond + "]", nil
+}
+
+// jmesPathIdentifier returns key as an unquoted identifier when JMESPath
+// allows it, or as a quoted identifier such as "foo-bar"
+func jmesPathIdentifier(key string) string {
+	if isPlainIdentifier(key) {
+		return key
+	}
+	return quoteJSONString(key)
+}
+
+var pythonFormat = pathFormat{
+	Name: "Python",
+	Path: func(segs []pathSegment) (string, error) {
+		return formatIndexChain(segs, "data", false), nil
+	},
+}
+
+var javaScriptFormat = pathFormat{
+	Name: "JavaScript",
+	Path: func(segs []pathSegment) (string, error) {
+		return formatIndexChain(segs, "data", true), nil
+	},
+}
+
+// formatIndexChain renders segments as subscripts on a variable, as in
+// data["users"][0] for Python. In JavaScript mode identifiers use dot access
+// and every step after the first is optional chaining, data.users?.[0].
+func formatIndexChain(segs []pathSegment, variable string, js bool) string {
+	var b strings.Builder
+	b.WriteString(variable)
+	for i, seg := range segs {
+		if js && i > 0 {
+			b.WriteString("?.")
+		}
+		switch {
+		case seg.IsIndex:
+			b.WriteString("[" + strconv.Itoa(seg.Index) + "]")
+		case js && isPlainIdentifier(seg.Key):
+			if i == 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(seg.Key)
+		default:
+			// JSON string literals are valid in both languages
+			b.WriteString("[" + quoteJSONString(seg.Key) + "]")
+		}
+	}
+	return b.String()
+}
+
+var gjsonFormat = pathFormat{
+	Name: "Go gjson",
+	Path: formatGJSONPath,
+	Wildcard: func(segs []pathSegment, form int) (string, error) {
+		return formatGJSONPath(segs)
+	},
+	WildcardForms: 1,
+	Predicate:     formatGJSONPredicate,
+	Usage: func(query string) string {
+		return "Go: gjson.Get(json, " + strconv.Quote(query) + ")"
+	},
+}
+
+// formatGJSONPath renders segments as a gjson path, users.0.name, with
+// users.#.name for every element of an array. A trailing # would count the
+// elements, so trailing iterators are left off, and the arrays of arrays
+// nested iterators give are flattened into one list like jq's output.
+func formatGJSONPath(segs []pathSegment) (string, error) {
+	iterators := 0
+	for _, seg := range segs {
+		if seg.Iterate {
+			iterators++
+		}
+	}
+	for len(segs) > 0 && segs[len(segs)-1].Iterate {
+		segs = segs[:len(segs)-1]
+	}
+
+	parts := make([]string, len(segs))
+	for i, seg := range segs {
+		switch {
+		case seg.Iterate:
+			parts[i] = "#"
+		case seg.IsIndex:
+			parts[i] = strconv.Itoa(seg.Index)
+		case seg.Key == "":
+			return "", fmt.Errorf("gjson paths cannot address an empty key")
+		default:
+			parts[i] = escapeGJSONKey(seg.Key)
+		}
+	}
+	path := strings.Join(parts, ".")
+	if path == "" {
+		path = "@this"
+	}
+	for i := 1; i < iterators; i++ {
+		path += "|@flatten"
+	}
+	return path, nil
+}
+
+// formatGJSONPredicate renders a predicate as a query returning every
+// match, users.#(active==false)#
+func formatGJSONPredicate(p predicate) (string, error) {
+	switch p.Op {
+	case "==", "!=", "<", ">":
+	default:
+		return "", fmt.Errorf("gjson queries have no %s operator; press o for a comparison", p.Op)
+	}
+	if len(p.Base) == 0 {
+		return "", fmt.Errorf("gjson queries select array elements; the value is not inside an array")
+	}
+
+	field := ""
+	if len(p.Field) > 0 {
+		path, err := formatGJSONPath(p.Field)
+		if err != nil {
+			return "", err
+		}
+		field = path
+	}
+	query := "#(" + field + p.Op + p.Literal + ")#"
+	if len(p.Base) == 1 {
+		return query, nil
+	}
+	array, err := formatGJSONPath(p.Base[:len(p.Base)-1])
+	if err != nil {
+		return "", err
+	}
+	return array + "." + query, nil
+}
+
+// escapeGJSONKey escapes the characters gjson treats as path syntax, like
+// gjson.Escape
+func escapeGJSONKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		safe := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c <= ' ' || c > '~' || c == '_' || c == '-' || c == ':'
+		if !safe {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// yqFormat writes paths in mikefarah/yq syntax, which shares jq's path
+// expressions and select() but not all of its builtins
+var yqFormat = pathFormat{
+	Name: "yq",
+	Path: func(segs []pathSegment) (string, error) {
+		return formatJqPath(segs), nil
+	},
+	Wildcard: func(segs []pathSegment, form int) (string, error) {
+		return formatJqPath(segs), nil
+	},
+	WildcardForms: 1,
+	Predicate: func(p predicate) (string, error) {
+		switch p.Op {
+		case "==", "!=", "<", ">":
+			return formatJqPredicate(p), nil
+		}
+		return "", fmt.Errorf("yq output is limited to comparisons; press o for another operator")
+	},
+}
+
+// yqStreamFormat writes yq paths for a multi-document YAML stream, which
+// jqpick shows as a root array but yq evaluates one document at a time:
+// .[1].kind becomes select(document_index == 1) | .kind
+var yqStreamFormat = pathFormat{
+	Name: "yq",
+	Path: func(segs []pathSegment) (string, error) {
+		return formatYqStream(segs, formatJqPath(tail(segs))), nil
+	},
+	Wildcard: func(segs []pathSegment, form int) (string, error) {
+		return formatYqStream(segs, formatJqPath(tail(segs))), nil
+	},
+	WildcardForms: 1,
+	Predicate: func(p predicate) (string, error) {
+		if len(p.Base) == 0 {
+			return yqFormat.Predicate(p)
+		}
+		inner := p
+		inner.Base = p.Base[1:]
+		query, err := yqFormat.Predicate(inner)
+		if err != nil {
+			return "", err
+		}
+		return formatYqStream(p.Base, query), nil
+	},
+}
+
+// formatYqStream prefixes query, which applies within one document, with the
+// document selected by the first segment. An iterator over the documents
+// needs no prefix since yq visits every document anyway.
+func formatYqStream(segs []pathSegment, query string) string {
+	if len(segs) == 0 || !segs[0].IsIndex {
+		return query
+	}
+	selectDoc := "select(document_index == " + strconv.Itoa(segs[0].Index) + ")"
+	if query == "." {
+		return selectDoc
+	}
+	return selectDoc + " | " + query
+}
+
+func tail(segs []pathSegment) []pathSegment {
+	if len(segs) == 0 {
+		return nil
+	}
+	return segs[1:]
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// decodeJSONC decodes JSON with comments and trailing commas, along with the
+// JSON5 extensions found in configuration files: unquoted and single-quoted
+// keys, single-quoted strings, hexadecimal numbers, leading or trailing
+// decimal points and explicit plus signs. Numbers are rewritten as JSON
+// literals; Infinity and NaN have no JSON form and are kept as strings.
+//
+// Comments are returned keyed by the jq path of the value they annotate: the
+// value that follows them, or the value they trail on the same line.
+func decodeJSONC(data []byte) (interface{}, map[string]string, error) {
+	p := &jsoncParser{data: data, comments: make(map[string]string)}
+	p.skipSpace()
+	value, err := p.value(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.trailingComment(nil)
+	p.skipSpace()
+	if p.pos < len(p.data) {
+		return nil, nil, p.errorf("unexpected %s after top-level value", p.describe())
+	}
+	p.attach(nil)
+	return value, p.comments, nil
+}
+
+type jsoncParser struct {
+	data     []byte
+	pos      int
+	comments map[string]string
+	pending  []string // comments waiting for the value they precede
+}
+
+func (p *jsoncParser) errorf(format string, args ...interface{}) error {
+	line, col := 1, 1
+	for _, c := range p.data[:p.pos] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else if c&0xC0 != 0x80 {
+			col++
+		}
+	}
+	return &offsetError{
+		offset: int64(p.pos),
+		msg:    fmt.Sprintf("line %d, column %d: %s", line, col, fmt.Sprintf(format, args...)),
+	}
+}
+
+// describe names the byte at the current position for error messages
+func (p *jsoncParser) describe() string {
+	if p.pos >= len(p.data) {
+		return "end of input"
+	}
+	r, _ := utf8.DecodeRune(p.data[p.pos:])
+	return strconv.QuoteRune(r)
+}
+
+func (p *jsoncParser) peek() byte {
+	if p.pos >= len(p.data) {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+// skipSpace skips whitespace and collects the comments it passes
+func (p *jsoncParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == ' ', c == '\t', c == '\r', c == '\n', c == '\f', c == '\v':
+			p.pos++
+		case c == 0xEF && bytes.HasPrefix(p.data[p.pos:], []byte("\uFEFF")):
+			p.pos += 3
+		case c == '/' && p.pos+1 < len(p.data) && (p.data[p.pos+1] == '/' || p.data[p.pos+1] == '*'):
+			if text, ok := p.comment(); ok {
+				p.pending = append(p.pending, text)
+			} else {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// comment reads the comment at the current position and returns its text
+// without the comment markers. An unterminated block comment is left for
+// the caller to report.
+func (p *jsoncParser) comment() (string, bool) {
+	rest := p.data[p.pos:]
+	if bytes.HasPrefix(rest, []byte("//")) {
+		end := bytes.IndexByte(rest, '\n')
+		if end < 0 {
+			end = len(rest)
+		}
+		p.pos += end
+		return strings.TrimSpace(string(rest[2:end])), true
+	}
+	end := bytes.Index(rest[2:], []byte("*/"))
+	if end < 0 {
+		return "", false
+	}
+	p.pos += end + 4
+	text := strings.TrimSpace(string(rest[2 : end+2]))
+	return strings.Join(strings.Fields(strings.TrimLeft(text, "*")), " "), true
+}
+
+// trailingComment attaches a comment that follows on the same line to the
+// value at segs
+func (p *jsoncParser) trailingComment(segs []pathSegment) {
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.pos++
+	}
+	if rest := p.data[p.pos:]; bytes.HasPrefix(rest, []byte("//")) || bytes.HasPrefix(rest, []byte("/*")) {
+		start := p.pos
+		text, ok := p.comment()
+		if !ok {
+			return
+		}
+		// A block comment spanning lines describes what follows it
+		if bytes.IndexByte(p.data[start:p.pos], '\n') >= 0 {
+			p.pending = append(p.pending, text)
+			return
+		}
+		p.addComment(segs, text)
+	}
+}
+
+// attach gives the pending comments to the value at segs
+func (p *jsoncParser) attach(segs []pathSegment) {
+	for _, text := range p.pending {
+		p.addComment(segs, text)
+	}
+	p.pending = nil
+}
+
+func (p *jsoncParser) addComment(segs []pathSegment, text string) {
+	if text == "" {
+		return
+	}
+	key := formatJqPath(segs)
+	if existing := p.comments[key]; existing != "" {
+		text = existing + " " + text
+	}
+	p.comments[key] = text
+}
+
+// value parses the value at the current position, which belongs at segs
+func (p *jsoncParser) value(segs []pathSegment) (interface{}, error) {
+	p.attach(segs)
+	switch c := p.peek(); {
+	case c == '{':
+		return p.object(segs)
+	case c == '[':
+		return p.array(segs)
+	case c == '"' || c == '\'':
+		return p.string()
+	case c == '-' || c == '+' || c == '.' || c >= '0' && c <= '9':
+		return p.number()
+	case isIdentifierStart(rune(c)) || c >= utf8.RuneSelf:
+		start := p.pos
+		word := p.identifier()
+		switch word {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		case "Infinity", "NaN":
+			return word, nil
+		}
+		if word == "" {
+			return nil, p.errorf("unexpected %s", p.describe())
+		}
+		p.pos = start
+		return nil, p.errorf("unexpected %q", word)
+	default:
+		return nil, p.errorf("unexpected %s, expecting a value", p.describe())
+	}
+}
+
+func (p *jsoncParser) object(segs []pathSegment) (interface{}, error) {
+	p.pos++ // {
+	p.trailingComment(segs)
+	obj := orderedObject{}
+	seen := make(map[string]int)
+	for {
+		p.skipSpace()
+		if p.peek() == '}' {
+			// Comments before the closing brace describe the object
+			p.attach(segs)
+			p.pos++
+			return obj, nil
+		}
+
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.peek() != ':' {
+			return nil, p.errorf("unexpected %s, expecting ':' after key %q", p.describe(), key)
+		}
+		p.pos++
+		p.skipSpace()
+
+		child := append(append([]pathSegment{}, segs...), pathSegment{Key: key})
+		value, err := p.value(child)
+		if err != nil {
+			return nil, err
+		}
+		// The last duplicate key wins, as with encoding/json
+		if i, dup := seen[key]; dup {
+			obj[i].Value = value
+		} else {
+			seen[key] = len(obj)
+			obj = append(obj, objectField{Key: key, Value: value})
+		}
+
+		if !p.separator(child, '}') {
+			return nil, p.errorf("unexpected %s, expecting ',' or '}'", p.describe())
+		}
+	}
+}
+
+func (p *jsoncParser) array(segs []pathSegment) (interface{}, error) {
+	p.pos++ // [
+	p.trailingComment(segs)
+	arr := []interface{}{}
+	for {
+		p.skipSpace()
+		if p.peek() == ']' {
+			p.attach(segs)
+			p.pos++
+			return arr, nil
+		}
+
+		child := append(append([]pathSegment{}, segs...), pathSegment{Key: strconv.Itoa(len(arr)), Index: len(arr), IsIndex: true})
+		value, err := p.value(child)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, value)
+
+		if !p.separator(child, ']') {
+			return nil, p.errorf("unexpected %s, expecting ',' or ']'", p.describe())
+		}
+	}
+}
+
+// separator consumes the comma after a member, along with a comment
+// trailing the member on its line. It reports false when neither a comma nor
+// the closing bracket follows.
+func (p *jsoncParser) separator(segs []pathSegment, closing byte) bool {
+	p.trailingComment(segs)
+	p.skipSpace()
+	switch p.peek() {
+	case ',':
+		p.pos++
+		p.trailingComment(segs)
+		return true
+	case closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// key reads an object key: a string or a bare identifier
+func (p *jsoncParser) key() (string, error) {
+	if c := p.peek(); c == '"' || c == '\'' {
+		return p.string()
+	}
+	if key := p.identifier(); key != "" {
+		return key, nil
+	}
+	return "", p.errorf("unexpected %s, expecting a key", p.describe())
+}
+
+// identifier reads a bare word such as an unquoted key or a literal
+func (p *jsoncParser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.data) {
+		r, size := utf8.DecodeRune(p.data[p.pos:])
+		if !isIdentifierStart(r) && !(p.pos > start && (unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r))) {
+			break
+		}
+		p.pos += size
+	}
+	return string(p.data[start:p.pos])
+}
+
+func isIdentifierStart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r)
+}
+
+// string reads a string quoted with ' or ", unescaping JSON and JSON5
+// escape sequences
+func (p *jsoncParser) string() (string, error) {
+	quote := p.data[p.pos]
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.data) {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.data[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String(), nil
+		case c == '\n':
+			return "", p.errorf("unterminated string")
+		case c != '\\':
+			b.WriteByte(c)
+			p.pos++
+			continue
+		}
+
+		p.pos++ // backslash
+		if p.pos >= len(p.data) {
+			return "", p.errorf("unterminated string")
+		}
+		esc := p.data[p.pos]
+		p.pos++
+		switch esc {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '0':
+			b.WriteByte(0)
+		case '\n':
+			// Line continuation
+		case '\r':
+			if p.peek() == '\n' {
+				p.pos++
+			}
+		case 'x':
+			r, err := p.hex(2)
+			if err != nil {
+				return "", err
+			}
+			b.WriteRune(r)
+		case 'u':
+			r, err := p.hex(4)
+			if err != nil {
+				return "", err
+			}
+			if utf16.IsSurrogate(r) && bytes.HasPrefix(p.data[p.pos:], []byte(`\u`)) {
+				p.pos += 2
+				low, err := p.hex(4)
+				if err != nil {
+					return "", err
+				}
+				r = utf16.DecodeRune(r, low)
+			}
+			b.WriteRune(r)
+		default:
+			// Any other escaped character stands for itself
+			b.WriteByte(esc)
+		}
+	}
+}
+
+func (p *jsoncParser) hex(digits int) (rune, error) {
+	if p.pos+digits > len(p.data) {
+		return 0, p.errorf("truncated escape sequence")
+	}
+	n, err := strconv.ParseUint(string(p.data[p.pos:p.pos+digits]), 16, 32)
+	if err != nil {
+		return 0, p.errorf("invalid escape sequence")
+	}
+	p.pos += digits
+	return rune(n), nil
+}
+
+// number reads a number and rewrites it as a JSON literal
+func (p *jsoncParser) number() (interface{}, error) {
+	start := p.pos
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '+' || c == '-' || c == '.' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	literal := string(p.data[start:p.pos])
+
+	sign, digits := "", literal
+	if strings.HasPrefix(digits, "+") || strings.HasPrefix(digits, "-") {
+		sign, digits = strings.TrimPrefix(digits[:1], "+"), digits[1:]
+	}
+
+	var normalized string
+	switch lower := strings.ToLower(digits); {
+	case lower == "infinity", lower == "nan":
+		return sign + digits, nil
+	case strings.HasPrefix(lower, "0x"):
+		var n big.Int
+		if _, ok := n.SetString(digits[2:], 16); !ok {
+			p.pos = start
+			return nil, p.errorf("invalid number %s", literal)
+		}
+		normalized = sign + n.String()
+	default:
+		if strings.HasPrefix(digits, ".") {
+			digits = "0" + digits
+		}
+		digits = strings.Replace(digits, ".e", "e", 1)
+		digits = strings.Replace(digits, ".E", "E", 1)
+		normalized = sign + strings.TrimSuffix(digits, ".")
+	}
+
+	if !isJSONNumber(normalized) {
+		p.pos = start
+		return nil, p.errorf("invalid number %s", literal)
+	}
+	return json.Number(normalized), nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeJSONC(t *testing.T) {
+	input := `// Editor settings
+{
+	/* Font */
+	"editor.fontSize": 14, // points
+	editor: {
+		tabSize: 0x10,
+		'rulers': [80, 120,],
+		ratio: .5,
+		scale: +2.,
+	},
+	"path": "C:\\tmp\\x", // trailing commas below
+	"infinite": -Infinity,
+}
+`
+	data, comments, err := decodeJSONC([]byte(input))
+	if err != nil {
+		t.Fatalf("decodeJSONC: %v", err)
+	}
+
+	root := buildJSONTree(data, nil, "")
+	root.attachComments(comments)
+	if got := strings.Join(childKeys(root), ","); got != "editor.fontSize,editor,path,infinite" {
+		t.Fatalf("Unexpected keys %s", got)
+	}
+	editor := root.Children[1]
+
+	values := []struct {
+		node     *JSONNode
+		expected string
+	}{
+		{editor.Children[0], "16"},
+		{editor.Children[1], "[...] (2 items)"},
+		{editor.Children[2], "0.5"},
+		{editor.Children[3], "2"},
+		{root.Children[2], `"C:\tmp\x"`},
+		{root.Children[3], `"-Infinity"`},
+	}
+	for _, tt := range values {
+		if got := tt.node.getValuePreview(); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.node.Key, tt.expected, got)
+		}
+	}
+
+	annotated := []struct {
+		node     *JSONNode
+		expected string
+	}{
+		{root, "Editor settings"},
+		{root.Children[0], "Font points"},
+		{root.Children[2], "trailing commas below"},
+	}
+	for _, tt := range annotated {
+		if tt.node.Comment != tt.expected {
+			t.Errorf("%s: expected comment %q, got %q", tt.node.Key, tt.expected, tt.node.Comment)
+		}
+	}
+	if editor.Comment != "" {
+		t.Errorf("Expected no comment on editor, got %q", editor.Comment)
+	}
+}
+
+func TestDecodeJSONCErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Missing comma", "{\n  \"a\": 1\n  \"b\": 2\n}", "line 3, column 3"},
+		{"Unterminated string", `{"a": "b}`, "unterminated string"},
+		{"Bad literal", `{"a": yes}`, `unexpected "yes"`},
+		{"Trailing data", `{} {}`, "after top-level value"},
+		{"Leading zero", `[007]`, "invalid number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decodeJSONC([]byte(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestParseDocumentFallsBackToJSONC(t *testing.T) {
+	doc, err := parseDocument([]byte("{\"a\": 1, // one\n}"), loadOptions{source: sourceJSON})
+	if err != nil {
+		t.Fatalf("parseDocument: %v", err)
+	}
+	if doc.source != sourceJSONC || doc.root.Children[0].Comment != "one" {
+		t.Errorf("Expected the JSONC parser with its comment")
+	}
+}
+
+func TestDecodeJSONCLargeInput(t *testing.T) {
+	// Comments are read in place, so each one costs its own length rather
+	// than the rest of the input
+	var b strings.Builder
+	b.WriteString("[\n")
+	for i := 0; i < 50000; i++ {
+		b.WriteString("\t// a record\n\t{\"id\": 1, /* inline */ \"ok\": true}, // done\n")
+	}
+	b.WriteString("]\n")
+
+	start := time.Now()
+	data, comments, err := decodeJSONC([]byte(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected %d KB to decode in well under 5s, took %v", b.Len()/1024, elapsed)
+	}
+	if len(data.([]interface{})) != 50000 || comments[".[49999]"] != "a record done" {
+		t.Errorf("Unexpected result: %d records, last comment %q", len(data.([]interface{})), comments[".[49999]"])
+	}
+}
@@ -0,0 +1,669 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// lazyThreshold is the input size from which the tree is indexed with a
+// streaming tokenizer and children are only read when a node is expanded
+const lazyThreshold = 64 << 20
+
+// byteSpan locates a container value inside a seekable input. Until the
+// container is expanded its span is all the tree keeps of it.
+type byteSpan struct {
+	src    io.ReaderAt
+	start  int64 // offset of the opening bracket
+	end    int64 // offset just past the closing bracket
+	loaded bool
+}
+
+// spanEntry describes one value found while scanning the input
+type spanEntry struct {
+	key     string
+	start   int64
+	end     int64
+	kind    byte   // '{' or '[' for containers, 0 for scalars
+	count   int    // number of direct children of a container
+	literal []byte // raw text of a scalar
+}
+
+// tokenScanner is a minimal JSON tokenizer that tracks byte offsets and can
+// skip over whole subtrees without allocating them
+type tokenScanner struct {
+	r        *bufio.Reader
+	off      int64
+	line     int  // 1-based line of off when scanning starts at a line
+	oneLine  bool // containers must end on the line they start on, as in JSON Lines
+	progress func(off int64)
+	reported int64
+}
+
+func newTokenScanner(r io.Reader, base int64, progress func(off int64)) *tokenScanner {
+	return &tokenScanner{
+		r:        bufio.NewReaderSize(r, 1<<16),
+		off:      base,
+		line:     1,
+		progress: progress,
+		reported: base,
+	}
+}
+
+func (s *tokenScanner) readByte() (byte, error) {
+	c, err := s.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	s.off++
+	if c == '\n' {
+		s.line++
+	}
+	if s.progress != nil && s.off-s.reported >= 1<<20 {
+		s.reported = s.off
+		s.progress(s.off)
+	}
+	return c, nil
+}
+
+// peek returns the next non-whitespace byte without consuming it. It returns
+// io.EOF only when the input ends cleanly.
+func (s *tokenScanner) peek() (byte, error) {
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !isSpace(c) {
+			return c, s.r.UnreadByte()
+		}
+		s.off++
+		if c == '\n' {
+			s.line++
+		}
+	}
+}
+
+func (s *tokenScanner) errorf(format string, args ...interface{}) error {
+	return &offsetError{offset: s.off, msg: fmt.Sprintf("%s at offset %d", fmt.Sprintf(format, args...), s.off)}
+}
+
+func (s *tokenScanner) expect(want byte) error {
+	c, err := s.peek()
+	if err != nil {
+		return s.unexpectedEnd(err)
+	}
+	if c != want {
+		return s.errorf("invalid character %q, expected %q", c, want)
+	}
+	_, err = s.readByte()
+	return err
+}
+
+func (s *tokenScanner) unexpectedEnd(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.errorf("unexpected end of JSON input")
+	}
+	return err
+}
+
+// scanValue reads one complete value. Containers are skipped and only their
+// direct children are counted.
+func (s *tokenScanner) scanValue() (spanEntry, error) {
+	c, err := s.peek()
+	if err != nil {
+		return spanEntry{}, s.unexpectedEnd(err)
+	}
+
+	e := spanEntry{start: s.off}
+	switch {
+	case c == '{' || c == '[':
+		e.kind = c
+		e.count, err = s.skipContainer()
+	case c == '"':
+		var buf bytes.Buffer
+		err = s.readString(&buf)
+		e.literal = buf.Bytes()
+	default:
+		e.literal, err = s.readLiteral()
+	}
+	if err != nil {
+		return spanEntry{}, err
+	}
+	e.end = s.off
+	return e, nil
+}
+
+// readString consumes a quoted string, copying its raw text to buf when buf
+// is not nil
+func (s *tokenScanner) readString(buf *bytes.Buffer) error {
+	if err := s.expect('"'); err != nil {
+		return err
+	}
+	if buf != nil {
+		buf.WriteByte('"')
+	}
+	escaped := false
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return s.unexpectedEnd(err)
+		}
+		if buf != nil {
+			buf.WriteByte(c)
+		}
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			return nil
+		case c < 0x20:
+			return s.errorf("invalid character %q in string literal", c)
+		}
+	}
+}
+
+// readLiteral consumes a number, true, false or null
+func (s *tokenScanner) readLiteral() ([]byte, error) {
+	return s.appendLiteral(nil)
+}
+
+// appendLiteral consumes a literal like readLiteral, appending it to lit
+func (s *tokenScanner) appendLiteral(lit []byte) ([]byte, error) {
+	start := len(lit)
+	for {
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isSpace(c) || c == ',' || c == ']' || c == '}' || c == ':' {
+			if err := s.r.UnreadByte(); err != nil {
+				return nil, err
+			}
+			break
+		}
+		s.off++
+		lit = append(lit, c)
+	}
+	if len(lit) == start {
+		c, err := s.peek()
+		if err != nil {
+			return nil, s.unexpectedEnd(err)
+		}
+		return nil, s.errorf("invalid character %q looking for beginning of value", c)
+	}
+	if !validLiteral(lit[start:]) {
+		return nil, s.errorf("invalid literal %q", lit[start:])
+	}
+	return lit, nil
+}
+
+// validLiteral reports whether lit is a JSON number, true, false or null.
+// Indexing checks every literal, which json.Valid makes noticeably slower.
+func validLiteral(lit []byte) bool {
+	switch string(lit) {
+	case "true", "false", "null":
+		return true
+	}
+	i := 0
+	digits := func() bool {
+		n := i
+		for i < len(lit) && lit[i] >= '0' && lit[i] <= '9' {
+			i++
+		}
+		return i > n
+	}
+	if i < len(lit) && lit[i] == '-' {
+		i++
+	}
+	if i < len(lit) && lit[i] == '0' {
+		i++
+	} else if !digits() {
+		return false
+	}
+	if i < len(lit) && lit[i] == '.' {
+		i++
+		if !digits() {
+			return false
+		}
+	}
+	if i < len(lit) && (lit[i] == 'e' || lit[i] == 'E') {
+		i++
+		if i < len(lit) && (lit[i] == '+' || lit[i] == '-') {
+			i++
+		}
+		if !digits() {
+			return false
+		}
+	}
+	return i == len(lit)
+}
+
+// endLine checks that only whitespace follows a JSON Lines record on line
+func (s *tokenScanner) endLine(line int) error {
+	c, err := s.peek()
+	if err == io.EOF || err == nil && s.line != line {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.errorf("invalid character %q after the record", c)
+}
+
+// seek moves the scanner to off, which is on line, reusing its buffer
+func (s *tokenScanner) seek(src io.ReaderAt, off, size int64, line int) {
+	s.r.Reset(io.NewSectionReader(src, off, size-off))
+	s.off, s.line, s.reported = off, line, off
+}
+
+// skipLine consumes the rest of the current line, including its newline
+func (s *tokenScanner) skipLine() error {
+	for {
+		chunk, err := s.r.ReadSlice('\n')
+		s.off += int64(len(chunk))
+		if s.progress != nil && s.off-s.reported >= 1<<20 {
+			s.reported = s.off
+			s.progress(s.off)
+		}
+		switch err {
+		case nil:
+			s.line++
+			return nil
+		case io.EOF:
+			return nil
+		case bufio.ErrBufferFull:
+			continue
+		default:
+			return err
+		}
+	}
+}
+
+// containerState is what an open container being skipped expects next
+type containerState struct {
+	open  byte
+	next  byte // '"' for a key, ':' after a key, 'v' for a value, ',' after a value
+	empty bool // nothing was read yet, so it may close right away
+}
+
+// skipContainer consumes an object or array, checking its syntax but not
+// keeping anything, and returns how many direct children it has. Repeated
+// keys of an object count once, as decoding keeps only the last.
+func (s *tokenScanner) skipContainer() (int, error) {
+	open, err := s.readByte()
+	if err != nil {
+		return 0, s.unexpectedEnd(err)
+	}
+	stack := []containerState{{open: open, next: firstExpected(open), empty: true}}
+	line := s.line
+	count := 0
+	var lit []byte
+	var raw bytes.Buffer
+	var keys map[string]bool
+
+	for len(stack) > 0 {
+		c, err := s.peek()
+		if err != nil {
+			return 0, s.unexpectedEnd(err)
+		}
+		if s.oneLine && s.line != line {
+			return 0, s.errorf("%s is not closed at the end of its line", containerName(stack[0].open))
+		}
+		top := &stack[len(stack)-1]
+		switch {
+		case c == closingBracket(top.open) && (top.empty || top.next == ','):
+			s.readByte()
+			stack = stack[:len(stack)-1]
+		case top.next == '"':
+			if c != '"' {
+				return 0, s.errorf("invalid character %q looking for beginning of object key string", c)
+			}
+			if len(stack) > 1 {
+				if err := s.readString(nil); err != nil {
+					return 0, err
+				}
+			} else {
+				raw.Reset()
+				if err := s.readString(&raw); err != nil {
+					return 0, err
+				}
+				key, err := s.objectKey(raw.Bytes())
+				if err != nil {
+					return 0, err
+				}
+				if keys == nil {
+					keys = make(map[string]bool)
+				}
+				if keys[key] {
+					count-- // its value is counted again below
+				}
+				keys[key] = true
+			}
+			top.next, top.empty = ':', false
+		case top.next == ':':
+			if err := s.expect(':'); err != nil {
+				return 0, err
+			}
+			top.next = 'v'
+		case top.next == ',':
+			if c != ',' {
+				return 0, s.errorf("invalid character %q after %s element", c, containerName(top.open))
+			}
+			s.readByte()
+			top.next = firstExpected(top.open)
+		default:
+			if len(stack) == 1 {
+				count++
+			}
+			top.next, top.empty = ',', false
+			switch c {
+			case '{', '[':
+				s.readByte()
+				stack = append(stack, containerState{open: c, next: firstExpected(c), empty: true})
+			case '"':
+				if err := s.readString(nil); err != nil {
+					return 0, err
+				}
+			default:
+				if lit, err = s.appendLiteral(lit[:0]); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	return count, nil
+}
+
+// firstExpected is what a container expects after its opening bracket or a
+// comma: a key in an object, a value in an array
+func firstExpected(open byte) byte {
+	if open == '{' {
+		return '"'
+	}
+	return 'v'
+}
+
+// objectKey decodes the raw text of an object key
+func (s *tokenScanner) objectKey(raw []byte) (string, error) {
+	if bytes.IndexByte(raw, '\\') < 0 && utf8.Valid(raw) {
+		return string(raw[1 : len(raw)-1]), nil
+	}
+	var key string
+	if err := json.Unmarshal(raw, &key); err != nil {
+		return "", s.errorf("invalid object key: %v", err)
+	}
+	return key, nil
+}
+
+// readChildren consumes a container and returns an entry for each direct
+// child, skipping over grandchildren. Like decodeValue, a repeated object
+// key keeps the place of its first occurrence and the last value.
+func (s *tokenScanner) readChildren() ([]spanEntry, error) {
+	open, err := s.readByte()
+	if err != nil {
+		return nil, s.unexpectedEnd(err)
+	}
+	closing := closingBracket(open)
+
+	var entries []spanEntry
+	seen := make(map[string]int)
+	if c, err := s.peek(); err != nil {
+		return nil, s.unexpectedEnd(err)
+	} else if c == closing {
+		_, err := s.readByte()
+		return entries, err
+	}
+
+	for {
+		var key string
+		if open == '{' {
+			var buf bytes.Buffer
+			if err := s.readString(&buf); err != nil {
+				return nil, err
+			}
+			if key, err = s.objectKey(buf.Bytes()); err != nil {
+				return nil, err
+			}
+			if err := s.expect(':'); err != nil {
+				return nil, err
+			}
+		}
+
+		e, err := s.scanValue()
+		if err != nil {
+			return nil, err
+		}
+		e.key = key
+		if i, dup := seen[key]; dup {
+			entries[i] = e
+		} else {
+			if open == '{' {
+				seen[key] = len(entries)
+			}
+			entries = append(entries, e)
+		}
+
+		c, err := s.peek()
+		if err != nil {
+			return nil, s.unexpectedEnd(err)
+		}
+		if _, err := s.readByte(); err != nil {
+			return nil, err
+		}
+		if c == closing {
+			return entries, nil
+		}
+		if c != ',' {
+			return nil, s.errorf("invalid character %q after %s element", c, containerName(open))
+		}
+	}
+}
+
+// recoveryWindow is how many lines may be skipped before an input whose
+// skipped lines outnumber its values is given up on as not JSON Lines
+const recoveryWindow = 16
+
+// indexJSON scans the input once and returns the root of a lazily loaded
+// tree. Several top-level values are presented as an array, like NDJSON.
+// When the values read so far were one per line, a broken value is skipped
+// from its line on, as with JSON Lines, and the lines skipped are returned
+// unless they outnumber the values read.
+func indexJSON(src io.ReaderAt, size int64, progress func(read, total int64)) (*JSONNode, []lineError, error) {
+	var onProgress func(int64)
+	if progress != nil {
+		onProgress = func(off int64) { progress(off, size) }
+	}
+	s := newTokenScanner(io.NewSectionReader(src, 0, size), 0, onProgress)
+
+	var values []spanEntry
+	var lines []int
+	var skipped []lineError
+	var firstErr error
+	lineOriented := true // every value so far sat on a line of its own
+	lastLine := 0        // line the previous value ended on
+	for {
+		if _, err := s.peek(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		start, line := s.off, s.line
+		e, err := s.scanValue()
+		end := s.line
+		if err == nil && s.oneLine {
+			err = s.endLine(line)
+		}
+		var syntaxErr *offsetError
+		if errors.As(err, &syntaxErr) {
+			if firstErr == nil {
+				firstErr = err
+			}
+			skipped = append(skipped, lineError{line: line, err: err})
+			if !lineOriented || line == lastLine ||
+				len(skipped) >= recoveryWindow && len(skipped) > len(values) {
+				return nil, nil, firstErr
+			}
+			// From here on the input is read as JSON Lines, so a broken
+			// record cannot run on into the records after it
+			if !s.oneLine {
+				s.seek(src, start, size, line)
+				s.oneLine = true
+			}
+			if s.line == line {
+				if err := s.skipLine(); err != nil {
+					return nil, nil, err
+				}
+			}
+			lastLine = s.line - 1
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if end != line || line == lastLine {
+			lineOriented = false
+		}
+		lastLine = end
+		values = append(values, e)
+		lines = append(lines, line)
+	}
+
+	if len(skipped) > 0 && len(skipped) >= len(values) {
+		return nil, nil, firstErr
+	}
+	if len(values) == 0 {
+		return nil, nil, fmt.Errorf("no valid JSON found")
+	}
+
+	var root *JSONNode
+	if len(values) == 1 {
+		var err error
+		if root, err = newLazyNode(src, values[0], nil, ""); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		root = &JSONNode{Type: "array", Children: []*JSONNode{}}
+		for i, e := range values {
+			child, err := newLazyNode(src, e, root, strconv.Itoa(i))
+			if err != nil {
+				return nil, nil, err
+			}
+			child.Index = i
+			child.Line = lines[i]
+			root.Children = append(root.Children, child)
+		}
+	}
+
+	if err := root.setExpanded(true); err != nil {
+		return nil, nil, err
+	}
+	return root, skipped, nil
+}
+
+// indexedStream reports whether root is the array indexJSON presents several
+// top-level values as, rather than a value of the input
+func indexedStream(root *JSONNode) bool {
+	return root.Type == "array" && root.Span == nil
+}
+
+// newLazyNode turns a scanned entry into a node. Scalars are decoded right
+// away, containers keep only their span until expanded.
+func newLazyNode(src io.ReaderAt, e spanEntry, parent *JSONNode, key string) (*JSONNode, error) {
+	if e.kind == 0 {
+		value, err := decodeJSON(e.literal)
+		if err != nil {
+			return nil, fmt.Errorf("at offset %d: %v", e.start, err)
+		}
+		node := buildJSONTree(value, parent, key)
+		node.Expanded = false
+		return node, nil
+	}
+
+	node := &JSONNode{
+		Key:    key,
+		Parent: parent,
+		Span:   &byteSpan{src: src, start: e.start, end: e.end},
+		Count:  e.count,
+	}
+	if e.kind == '{' {
+		node.Type = "object"
+	} else {
+		node.Type = "array"
+	}
+	return node, nil
+}
+
+// loadChildren reads the direct children of a lazily indexed container
+func (n *JSONNode) loadChildren() error {
+	if n.Embed != nil {
+		return n.loadEmbedded()
+	}
+	if n.Span == nil || n.Span.loaded {
+		return nil
+	}
+
+	span := n.Span
+	s := newTokenScanner(io.NewSectionReader(span.src, span.start, span.end-span.start), span.start, nil)
+	entries, err := s.readChildren()
+	if err != nil {
+		return err
+	}
+
+	children := make([]*JSONNode, 0, len(entries))
+	for i, e := range entries {
+		key := e.key
+		if n.Type == "array" {
+			key = strconv.Itoa(i)
+		}
+		child, err := newLazyNode(span.src, e, n, key)
+		if err != nil {
+			return err
+		}
+		child.Index = i
+		children = append(children, child)
+	}
+
+	n.Children = children
+	span.loaded = true
+	return nil
+}
+
+// rawJSON returns the input text of a lazily indexed container
+func (s *byteSpan) rawJSON() ([]byte, error) {
+	data := make([]byte, s.end-s.start)
+	if _, err := s.src.ReadAt(data, s.start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+func closingBracket(open byte) byte {
+	if open == '{' {
+		return '}'
+	}
+	return ']'
+}
+
+func containerName(open byte) string {
+	if open == '{' {
+		return "object"
+	}
+	return "array"
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
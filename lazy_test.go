@@ -0,0 +1,240 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func mustIndexTree(t *testing.T, input string) *JSONNode {
+	t.Helper()
+	root, _, err := indexJSON(strings.NewReader(input), int64(len(input)), nil)
+	if err != nil {
+		t.Fatalf("indexJSON: %v", err)
+	}
+	return root
+}
+
+func TestIndexJSONLoadsChildrenOnExpand(t *testing.T) {
+	input := `{"users": [{"name": "John", "tags": ["a", "b"]}, {"name": "Jane \"J\" Doe", "tags": []}], "count": 2, "meta": {}}`
+	root := mustIndexTree(t, input)
+
+	if got := strings.Join(childKeys(root), ","); got != "users,count,meta" {
+		t.Fatalf("Expected root keys users,count,meta, got %s", got)
+	}
+
+	users := root.Children[0]
+	if users.Expanded || len(users.Children) != 0 {
+		t.Fatalf("Expected users to be collapsed and not loaded")
+	}
+	if preview := users.getValuePreview(); preview != "[...] (2 items)" {
+		t.Errorf("Expected count from index, got %s", preview)
+	}
+	if preview := root.Children[1].getValuePreview(); preview != "2" {
+		t.Errorf("Expected scalar decoded eagerly, got %s", preview)
+	}
+	if preview := root.Children[2].getValuePreview(); preview != "{...} (0 keys)" {
+		t.Errorf("Expected empty object, got %s", preview)
+	}
+
+	if err := users.setExpanded(true); err != nil {
+		t.Fatalf("setExpanded: %v", err)
+	}
+	if len(users.Children) != 2 {
+		t.Fatalf("Expected 2 loaded children, got %d", len(users.Children))
+	}
+
+	jane := users.Children[1]
+	if err := jane.setExpanded(true); err != nil {
+		t.Fatalf("setExpanded: %v", err)
+	}
+	name := jane.Children[0]
+	if preview := name.getValuePreview(); preview != `"Jane "J" Doe"` {
+		t.Errorf("Unexpected name preview %s", preview)
+	}
+	if query := name.buildJqQuery(); query != ".users[1].name" {
+		t.Errorf("Expected .users[1].name, got %s", query)
+	}
+	if preview := jane.Children[1].getValuePreview(); preview != "[...] (0 items)" {
+		t.Errorf("Expected empty tags, got %s", preview)
+	}
+
+	raw, err := users.Children[0].compactJSON()
+	if err != nil {
+		t.Fatalf("compactJSON: %v", err)
+	}
+	if raw != `{"name":"John","tags":["a","b"]}` {
+		t.Errorf("Unexpected compact JSON %s", raw)
+	}
+}
+
+func TestIndexJSONDuplicateKeys(t *testing.T) {
+	// As when decoding, the last value of a repeated key wins in its first place
+	root := mustIndexTree(t, `{"a": 1, "b": {"x": 1, "x": 2}, "\u0061": 3}`)
+	if got := strings.Join(childKeys(root), ","); got != "a,b" {
+		t.Fatalf("Expected keys a,b, got %s", got)
+	}
+	if preview := root.Children[0].getValuePreview(); preview != "3" {
+		t.Errorf("Expected the last a, got %s", preview)
+	}
+	b := root.Children[1]
+	if preview := b.getValuePreview(); preview != "{...} (1 keys)" {
+		t.Errorf("Expected the repeated key to count once, got %s", preview)
+	}
+	if err := b.setExpanded(true); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Children) != 1 || b.Children[0].getValuePreview() != "2" {
+		t.Errorf("Expected b to hold only x: 2, got %d children", len(b.Children))
+	}
+}
+
+func TestIndexJSONMultipleValues(t *testing.T) {
+	root := mustIndexTree(t, "{\"a\":1}\n{\"a\":2}{\"a\":[3]}\n")
+
+	if root.Type != "array" || len(root.Children) != 3 || !indexedStream(root) {
+		t.Fatalf("Expected array of 3 records, got %s with %d children", root.Type, len(root.Children))
+	}
+	if indexedStream(mustIndexTree(t, `[{"a": 1}]`)) {
+		t.Error("Expected a single array not to be a stream")
+	}
+	if query := root.Children[2].buildJqQuery(); query != ".[2]" {
+		t.Errorf("Expected .[2], got %s", query)
+	}
+	if root.Children[1].Line != 2 || root.Children[2].Line != 2 {
+		t.Errorf("Expected the last records on line 2, got %d and %d", root.Children[1].Line, root.Children[2].Line)
+	}
+	if raw, err := root.compactJSON(); err != nil || raw != `[{"a":1},{"a":2},{"a":[3]}]` {
+		t.Errorf("Expected the records as one array, got %s (%v)", raw, err)
+	}
+}
+
+func TestIndexJSONLines(t *testing.T) {
+	input := "{\"n\": 1}\n{\"n\": \n\n  {\"n\": [3]}\nnot json\n\"ok\"\n"
+	root, skipped, err := indexJSON(strings.NewReader(input), int64(len(input)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Children) != 3 || len(skipped) != 2 || skipped[0].line != 2 || skipped[1].line != 5 {
+		t.Fatalf("Expected 3 records and lines 2 and 5 skipped, got %d and %v", len(root.Children), skipped)
+	}
+	for i, line := range []int{1, 4, 6} {
+		if got := root.Children[i].Line; got != line {
+			t.Errorf("Expected record %d on line %d, got %d", i, line, got)
+		}
+	}
+
+	// Once a line was skipped, a record cut off at the end of its line does
+	// not run on into the next ones
+	input = "oops\n{\"a\": [1,\n2]}\n{\"n\": 1}\n{\"n\": 2}\n{\"n\": 3}\n{\"n\": 4}\n"
+	root, skipped, err = indexJSON(strings.NewReader(input), int64(len(input)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Children) != 4 || len(skipped) != 3 || skipped[1].line != 2 || root.Children[0].Line != 4 {
+		t.Errorf("Expected 4 records from line 4 and lines 1-3 skipped, got %d and %v", len(root.Children), skipped)
+	}
+
+	// Mostly broken input is an error, not a list of skipped lines
+	broken := "{\"a\": [1,\n  2}\n"
+	if _, _, err := indexJSON(strings.NewReader(broken), int64(len(broken)), nil); err == nil {
+		t.Error("Expected a broken document to fail")
+	}
+}
+
+func TestIndexJSONTruncatedDocument(t *testing.T) {
+	// A large pretty-printed document cut off at the end is reported as it
+	// is, not read again as thousands of broken JSON Lines records
+	var b strings.Builder
+	b.WriteString("{\n  \"items\": [\n")
+	for i := 0; i < 200000; i++ {
+		b.WriteString("    {\n      \"id\": 1,\n      \"name\": \"item\"\n    },\n")
+	}
+	input := b.String()
+	_, _, err := indexJSON(strings.NewReader(input), int64(len(input)), nil)
+	if err == nil || !strings.Contains(err.Error(), "unexpected end of JSON input") {
+		t.Fatalf("Expected the document to be reported as truncated, got %v", err)
+	}
+
+	// Nor are records that did not each sit on a line of their own
+	input = "{\"a\": 1}\n{\n  \"a\": 2\n}\n{\"a\": \n"
+	if _, _, err := indexJSON(strings.NewReader(input), int64(len(input)), nil); err == nil {
+		t.Error("Expected a broken pretty-printed record to fail")
+	}
+}
+
+func TestIndexJSONErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"Unterminated object", `{"a": 1`},
+		{"Mismatched bracket", `{"a": [1}`},
+		{"Bad literal", `{"a": tru}`},
+		{"Missing value", `{"v": }`},
+		{"Missing colon", `{"a" 1}`},
+		{"Trailing comma", `[1, [2,]]`},
+		{"Missing comma", `{"a": [1 2]}`},
+		{"Empty input", "  \n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Containers are checked while indexing, not when expanded
+			_, _, err := indexJSON(strings.NewReader(tt.input), int64(len(tt.input)), nil)
+			if err == nil {
+				t.Errorf("Expected an error for %q", tt.input)
+			}
+		})
+	}
+}
+
+func TestWildcardOnLazyInput(t *testing.T) {
+	root := mustIndexTree(t, `{"users": [{"id": 1}, {"id": 2}, {"id": 3}]}`)
+	users := root.Children[0]
+	if err := users.setExpanded(true); err != nil {
+		t.Fatal(err)
+	}
+	first := users.Children[0]
+	if err := first.setExpanded(true); err != nil {
+		t.Fatal(err)
+	}
+	m := model{root: root, doc: &document{root: root, lazy: true}}
+
+	m.selectNode(first.Children[0])
+	m.cycleWildcard()
+	if m.query != ".users[].id" || m.queryNote != "" {
+		t.Errorf("Expected the wildcard without a count, got %s (%s)", m.query, m.queryNote)
+	}
+	if len(users.Children[2].Children) != 0 {
+		t.Error("Expected the other users to stay unloaded")
+	}
+}
+
+func TestFilterRefusedOnLazyInput(t *testing.T) {
+	root := mustIndexTree(t, `{"users": [{"id": 1}, {"id": 2}]}`)
+	m := model{root: root, doc: &document{root: root, lazy: true}}
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(":")})
+	m = updated.(model)
+	if m.filterMode || cmd != nil || m.filterDoc != nil {
+		t.Error("Expected the filter bar to stay closed without decoding the input")
+	}
+	if m.status == "" {
+		t.Error("Expected a status message explaining why")
+	}
+}
+
+func TestValidLiteral(t *testing.T) {
+	for _, lit := range []string{"0", "-1", "12.50", "1e9", "-0.5E-3", "true", "false", "null"} {
+		if !validLiteral([]byte(lit)) {
+			t.Errorf("Expected %s to be valid", lit)
+		}
+	}
+	for _, lit := range []string{"", "-", "01", "1.", ".5", "1e", "+1", "tru", "nul", "NaN", "1x"} {
+		if validLiteral([]byte(lit)) {
+			t.Errorf("Expected %q to be invalid", lit)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// document is a loaded input together with whatever keeps its lazily indexed
+// nodes readable
+type document struct {
+	root       *JSONNode
+	closer     io.Closer
+	lazy       bool         // nodes are read from the input on demand
+	source     sourceFormat // syntax the input was read as
+	stream     bool         // the root array holds the documents of a YAML stream or the values of a JSON stream
+	inferTypes bool         // CSV cells were given number, boolean and null types
+	quotedCSV  bool         // CSV fields are quoted, which csvConversion cannot read
+	skipped    []lineError  // invalid lines left out of a JSON Lines input
+	compressed *compression // container the input was decompressed from
+	broken     *parseError  // the input failed to parse; root is what was read before the error
+}
+
+// sourceFormat is the syntax of an input
+type sourceFormat int
+
+const (
+	sourceAuto  sourceFormat = iota // detected from the file name and contents
+	sourceJSON                      // JSON or JSON Lines
+	sourceJSONC                     // JSON with comments, trailing commas and JSON5 syntax
+	sourceYAML
+	sourceCSV
+	sourceTSV
+)
+
+// indexable reports whether large inputs of this syntax can be indexed
+// lazily, which only the JSON tokenizer supports
+func (s sourceFormat) indexable() bool {
+	return s == sourceAuto || s == sourceJSON
+}
+
+// loadOptions are the command-line settings that affect parsing
+type loadOptions struct {
+	source     sourceFormat
+	inferTypes bool // give CSV cells number, boolean and null types
+}
+
+// sourceFromName detects the syntax of a file from its extension
+func sourceFromName(path string) sourceFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return sourceYAML
+	case ".csv":
+		return sourceCSV
+	case ".tsv", ".tab":
+		return sourceTSV
+	case ".jsonc", ".json5":
+		return sourceJSONC
+	case ".json", ".jsonl", ".ndjson":
+		return sourceJSON
+	default:
+		return sourceAuto
+	}
+}
+
+func (d *document) Close() error {
+	if d == nil || d.closer == nil {
+		return nil
+	}
+	return d.closer.Close()
+}
+
+// loadProgressMsg reports how many bytes of the input have been read. Total
+// is -1 when the size is unknown, as with pipes.
+type loadProgressMsg struct {
+	read  int64
+	total int64
+	next  tea.Cmd // waits for the next message from the loader
+}
+
+// loadDoneMsg carries the loaded document or the reason loading failed
+type loadDoneMsg struct {
+	doc  *document
+	err  error
+	next tea.Cmd // waits for the records of a followed input
+}
+
+// loadFunc loads an input, reporting how much of it has been read
+type loadFunc func(progress func(read, total int64)) (*document, error)
+
+// startLoading loads the input in the background and returns a command that
+// delivers its progress and result messages
+func startLoading(load loadFunc) tea.Cmd {
+	ch := make(chan tea.Msg)
+	go func() {
+		doc, err := load(func(read, total int64) {
+			// Progress is best effort: drop updates while the UI is busy
+			select {
+			case ch <- loadProgressMsg{read: read, total: total}:
+			default:
+			}
+		})
+		ch <- loadDoneMsg{doc: doc, err: err}
+		close(ch)
+	}()
+	return waitForLoad(ch)
+}
+
+func waitForLoad(ch chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-ch
+		if !ok {
+			return nil
+		}
+		if progress, isProgress := msg.(loadProgressMsg); isProgress {
+			progress.next = waitForLoad(ch)
+			return progress
+		}
+		return msg
+	}
+}
+
+// loadInput reads and parses the file at path, or stdin when path is empty
+// or "-". JSON inputs of lazyThreshold bytes or more are indexed instead of
+// being decoded up front; stdin is spooled to a temporary file for that.
+// Compressed inputs are decompressed first and spooled like stdin.
+func loadInput(path string, opts loadOptions, progress func(read, total int64)) (*document, error) {
+	var (
+		src  *os.File
+		name = path
+	)
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", path, err)
+		}
+		src = f
+		if opts.source == sourceAuto {
+			opts.source = sourceFromName(trimCompressionExt(path))
+		}
+	} else {
+		src = os.Stdin
+		name = "stdin"
+	}
+
+	stat, err := src.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		if src != os.Stdin {
+			defer src.Close()
+		}
+		return loadPipe(src, name, opts, progress)
+	}
+	size := stat.Size()
+
+	// Sniff the magic bytes without consuming them
+	head := make([]byte, magicLen)
+	n, _ := src.ReadAt(head, 0)
+	if c := detectCompression(head[:n]); c != nil {
+		doc, err := loadCompressed(src, c, name, opts, progress)
+		if src != os.Stdin {
+			src.Close()
+		}
+		return doc, err
+	}
+
+	if size >= lazyThreshold && opts.source.indexable() {
+		root, skipped, err := indexJSON(src, size, progress)
+		if err != nil {
+			defer src.Close()
+			return nil, indexError(err, src, size)
+		}
+		return &document{root: root, closer: src, lazy: true, source: sourceJSON, stream: indexedStream(root), skipped: skipped}, nil
+	}
+
+	input, err := io.ReadAll(&progressReader{r: src, total: size, progress: progress})
+	if src != os.Stdin {
+		src.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", name, err)
+	}
+	return parseDocument(input, opts)
+}
+
+// loadPipe reads an input of unknown size, such as stdin or the output of a
+// command. Its magic bytes are sniffed through a buffer that replaces it as
+// the source.
+func loadPipe(r io.Reader, name string, opts loadOptions, progress func(read, total int64)) (*document, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(magicLen)
+	if c := detectCompression(head); c != nil {
+		return loadCompressed(br, c, name, opts, progress)
+	}
+	if opts.source.indexable() {
+		return loadStream(br, name, opts, progress)
+	}
+
+	input, err := io.ReadAll(&progressReader{r: br, total: -1, progress: progress})
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", name, err)
+	}
+	return parseDocument(input, opts)
+}
+
+// loadCompressed decompresses r and loads the result like an input of
+// unknown size
+func loadCompressed(r io.Reader, c *compression, name string, opts loadOptions, progress func(read, total int64)) (*document, error) {
+	dec, err := c.open(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %s: %v", name, c.name, err)
+	}
+	defer dec.Close()
+	in := &decompressReader{r: dec, name: c.name}
+
+	var doc *document
+	if opts.source.indexable() {
+		doc, err = loadStream(in, name, opts, progress)
+	} else {
+		var input []byte
+		input, err = io.ReadAll(&progressReader{r: in, total: -1, progress: progress})
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", name, err)
+		}
+		doc, err = parseDocument(input, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc.compressed = c
+	return doc, nil
+}
+
+// loadStream reads an input of unknown size, switching to a temporary file
+// and lazy indexing once it grows past lazyThreshold
+func loadStream(r io.Reader, name string, opts loadOptions, progress func(read, total int64)) (*document, error) {
+	pr := &progressReader{r: r, total: -1, progress: progress}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, pr, lazyThreshold); err == io.EOF {
+		return parseDocument(buf.Bytes(), opts)
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", name, err)
+	}
+
+	tmp, err := os.CreateTemp("", "jqpick-*.json")
+	if err != nil {
+		return nil, err
+	}
+	spool := &tempFile{tmp}
+	if _, err := buf.WriteTo(tmp); err != nil {
+		spool.Close()
+		return nil, err
+	}
+	size, err := io.Copy(tmp, pr)
+	if err != nil {
+		spool.Close()
+		return nil, fmt.Errorf("reading %s: %v", name, err)
+	}
+	size += lazyThreshold
+
+	root, skipped, err := indexJSON(tmp, size, progress)
+	if err != nil {
+		defer spool.Close()
+		return nil, indexError(err, tmp, size)
+	}
+	return &document{root: root, closer: spool, lazy: true, source: sourceJSON, stream: indexedStream(root), skipped: skipped}, nil
+}
+
+// parseDocument decodes a fully read input into an eagerly built tree. JSON
+// that fails to parse is retried as JSONC, and when the syntax is not known,
+// inputs that do not start like JSON are tried as YAML. If all of them fail,
+// the error reported is the one that got furthest into the input, and when
+// that is the JSON error it comes with the part of the input that was valid.
+// Empty input is reported as such.
+func parseDocument(input []byte, opts loadOptions) (*document, error) {
+	switch opts.source {
+	case sourceYAML:
+		return parseYAMLDocument(input)
+	case sourceJSONC:
+		return parseJSONCDocument(input)
+	case sourceCSV, sourceTSV:
+		return parseCSVDocument(input, opts)
+	}
+
+	if len(bytes.TrimSpace(input)) == 0 {
+		// Nothing to locate, and the fallbacks would only fail at its start
+		return nil, errors.New("parsing JSON: the input is empty")
+	}
+	parsed, err := readJSONInput(input)
+	if err != nil {
+		failure := newParseError("JSON", err, input)
+		if partial, ok := decodeJSONPrefix(input); ok {
+			failure.partial = partial
+		}
+
+		doc, jsoncErr := parseJSONCDocument(input)
+		if jsoncErr == nil {
+			return doc, nil
+		}
+		failure = failure.further(asParseError(jsoncErr))
+		if opts.source == sourceAuto && !startsLikeJSON(input) {
+			doc, yamlErr := parseYAMLDocument(input)
+			if yamlErr == nil && doc.root.isContainer() {
+				return doc, nil
+			}
+			// YAML reports lines rather than offsets, and input that does
+			// not start like JSON was more likely meant as YAML
+			if e := asParseError(yamlErr); e != nil && e.offset >= failure.offset {
+				failure = e
+			}
+		}
+		return nil, failure
+	}
+
+	root := buildJSONTree(parsed.value, nil, "")
+	for i, line := range parsed.lines {
+		root.Children[i].Line = line
+	}
+	return &document{root: root, source: sourceJSON, stream: parsed.lines != nil, skipped: parsed.skipped}, nil
+}
+
+func parseJSONCDocument(input []byte) (*document, error) {
+	data, comments, err := decodeJSONC(input)
+	if err != nil {
+		return nil, newParseError("JSONC", err, input)
+	}
+	root := buildJSONTree(data, nil, "")
+	root.attachComments(comments)
+	return &document{root: root, source: sourceJSONC}, nil
+}
+
+func parseCSVDocument(input []byte, opts loadOptions) (*document, error) {
+	comma := ','
+	if opts.source == sourceTSV {
+		comma = '\t'
+	}
+	data, err := decodeCSV(input, comma, opts.inferTypes)
+	if err != nil {
+		return nil, newParseError("CSV", err, input)
+	}
+	return &document{
+		root:       buildJSONTree(data, nil, ""),
+		source:     opts.source,
+		inferTypes: opts.inferTypes,
+		quotedCSV:  csvQuoted(input, comma),
+	}, nil
+}
+
+func parseYAMLDocument(input []byte) (*document, error) {
+	data, stream, err := decodeYAML(input)
+	if err != nil {
+		return nil, newParseError("YAML", err, input)
+	}
+	return &document{root: buildJSONTree(data, nil, ""), source: sourceYAML, stream: stream}, nil
+}
+
+// asParseError returns err as a *parseError, or nil if it is something else
+func asParseError(err error) *parseError {
+	var e *parseError
+	if errors.As(err, &e) {
+		return e
+	}
+	return nil
+}
+
+// startsLikeJSON reports whether the first significant byte of input opens a
+// JSON object, array or string, or a JSONC comment
+func startsLikeJSON(input []byte) bool {
+	trimmed := bytes.TrimLeft(input, " \t\r\n")
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[' || trimmed[0] == '"' || trimmed[0] == '/')
+}
+
+// progressReader reports the number of bytes read so far
+type progressReader struct {
+	r        io.Reader
+	read     int64
+	total    int64
+	progress func(read, total int64)
+	reported int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.progress != nil && p.read-p.reported >= 1<<20 {
+		p.reported = p.read
+		p.progress(p.read, p.total)
+	}
+	return n, err
+}
+
+// tempFile removes the spooled input when the document is closed
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.Name())
+	return err
+}
@@ -1,14 +1,13 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
 	"os"
+	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 )
 
 // Version information (set by build flags)
@@ -25,79 +24,283 @@ type JSONNode struct {
 	Children []*JSONNode
 	Parent   *JSONNode
 	Expanded bool
+	Index    int       // position within the parent in document order
+	Span     *byteSpan // input location of a lazily indexed container
+	Count    int       // number of children of a container not loaded yet
+	Comment  string    // comment annotating the value in a JSONC input
+	Line     int       // input line a record of a JSON stream starts on
+
+	// Set on strings holding another value, such as serialized JSON, base64
+	// text or a JWT, which is decoded into their children
+	Embed *embedding
+
+	// Set on the tree of a watched file after it was reloaded
+	Change   changeKind  // how the node differs from the previous version of a watched file
+	Previous string      // value preview before the change, when Change is changeModified
+	Removed  []*JSONNode // children the previous version had, shown after Children
 }
 
 type model struct {
-	root       *JSONNode
-	cursor     int
-	selected   *JSONNode
-	viewport   int
-	height     int
-	width      int
-	jqQuery    string
-	showHelp   bool
-	searchMode bool
-	searchTerm string
-	filtered   []*JSONNode
-	wrapValues bool
-	filename   string
+	inputPath    string
+	options      loadOptions // parsing settings from the command line
+	loading      bool
+	progress     loadProgressMsg
+	loadErr      error
+	follow       bool        // append records to the root array as the input grows
+	followEnded  bool        // the followed input was closed
+	autoScroll   bool        // keep the cursor on the newest followed record
+	parseErr     *parseError // shown on the diagnostics screen until dismissed
+	watch        bool        // reload the input file when it changes
+	watchStamp   fileStamp   // version of the watched file last seen
+	reloading    bool
+	command      []string      // runs to produce the input, given after --
+	interval     time.Duration // how often the command runs again, 0 for never
+	doc          *document
+	status       string
+	root         *JSONNode
+	cursor       int
+	selected     *JSONNode
+	marked       []*JSONNode // nodes picked for an object-construction query
+	viewport     int
+	height       int
+	width        int
+	query        string // query in the active path format
+	queryErr     string // why the active format cannot express the query
+	jqQuery      string // jq form of the query, run by the embedded engine
+	queryKind    queryKind
+	format       int       // index into pathFormats
+	copyMenu     *JSONNode // node the copy-as menu renders, nil when closed
+	queryNote    string
+	predicateOp  int // index into predicateOps
+	countSeq     int // identifies the latest count of predicate matches
+	wildcardForm int
+	showHelp     bool
+	searchMode   bool
+	searchTerm   string
+	filtered     []*JSONNode
+	filterMode   bool        // typing in the jq filter bar
+	filterText   string      // jq filter evaluated by the embedded engine
+	filterSeq    int         // identifies the latest evaluation
+	filterRoot   *JSONNode   // output of the latest successful evaluation
+	filterErr    string      // error of the latest evaluation
+	filterDoc    interface{} // decoded document the filter runs on
+	wrapValues   bool
+	sortKeys     bool
+	filename     string
+	printMode    bool      // Enter prints the selection to stdout and exits
+	printKind    printKind // what is printed in print mode
+	accepted     bool      // the user confirmed a selection
+	output       string    // printed to stdout after the UI exits
 }
 
+// printKind is what jqpick prints when a selection is confirmed
+type printKind int
+
+const (
+	printQuery   printKind = iota // jq query
+	printRaw                      // value, with strings unquoted like jq -r
+	printCompact                  // value as compact JSON
+)
+
+// Exit codes
+const (
+	exitSelected  = 0
+	exitError     = 1
+	exitCancelled = 130
+)
+
 func main() {
-	filename := "file.json"
+	var path string
+	sortKeys := false
+	printMode := !isTerminal(os.Stdout)
+	kind := printQuery
+	var opts loadOptions
+	follow := false
+	watch := false
+	var command []string
+	var interval time.Duration
 
 	// Parse arguments
 	args := os.Args[1:]
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
+		case "--":
+			// The rest is the command to run
+			command = args[i+1:]
+			if len(command) == 0 {
+				fmt.Fprintf(os.Stderr, "Error: no command after --\n")
+				os.Exit(exitError)
+			}
+			i = len(args)
+		case "--interval", "-n":
+			if i+1 == len(args) {
+				fmt.Fprintf(os.Stderr, "Error: %s needs a number of seconds\n", args[i])
+				os.Exit(exitError)
+			}
+			i++
+			var err error
+			if interval, err = parseInterval(args[i]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(exitError)
+			}
 		case "--help", "-h":
 			printHelp()
 			return
 		case "--version", "-v":
 			printVersion()
 			return
+		case "--sort-keys", "-S":
+			sortKeys = true
+		case "--yaml":
+			opts.source = sourceYAML
+		case "--jsonc":
+			opts.source = sourceJSONC
+		case "--csv":
+			opts.source = sourceCSV
+		case "--tsv":
+			opts.source = sourceTSV
+		case "--infer-types":
+			opts.inferTypes = true
+		case "--follow", "-f":
+			follow = true
+		case "--watch", "-w":
+			watch = true
+		case "--print", "-p":
+			printMode = true
+		case "--raw-output", "-r":
+			printMode = true
+			kind = printRaw
+		case "--compact-output", "-c":
+			printMode = true
+			kind = printCompact
 		default:
-			// Treat as filename for display
-			filename = args[i]
+			if strings.HasPrefix(args[i], "-") && args[i] != "-" {
+				fmt.Fprintf(os.Stderr, "Error: unknown option %s (see --help)\n", args[i])
+				os.Exit(exitError)
+			}
+			if path != "" {
+				fmt.Fprintf(os.Stderr, "Error: only one input file can be given (got %q and %q)\n", path, args[i])
+				os.Exit(exitError)
+			}
+			path = args[i]
 		}
 	}
 
-	var input []byte
-	var err error
+	if follow && opts.source != sourceAuto && opts.source != sourceJSON {
+		fmt.Fprintf(os.Stderr, "Error: --follow reads JSON Lines and cannot be combined with --yaml, --jsonc, --csv or --tsv\n")
+		os.Exit(exitError)
+	}
+
+	if command != nil && (path != "" || follow || watch) {
+		fmt.Fprintf(os.Stderr, "Error: a command after -- is the input and cannot be combined with a file, --follow or --watch\n")
+		os.Exit(exitError)
+	}
+	if interval > 0 && command == nil {
+		fmt.Fprintf(os.Stderr, "Error: --interval repeats a command given after --, as in jqpick -n 5 -- kubectl get pods -o json\n")
+		os.Exit(exitError)
+	}
+	if watch && follow {
+		fmt.Fprintf(os.Stderr, "Error: --watch reloads a whole file and cannot be combined with --follow\n")
+		os.Exit(exitError)
+	}
+	if watch && (path == "" || path == "-") {
+		fmt.Fprintf(os.Stderr, "Error: --watch needs a file to watch, not stdin\n")
+		os.Exit(exitError)
+	}
+
+	if command == nil && (path == "" || path == "-") && !isStdinAvailable() {
+		fmt.Fprintf(os.Stderr, "Error: No input provided. Use: jqpick file.json or cat file.json | jqpick\n")
+		os.Exit(exitError)
+	}
+
+	// "-" reads stdin, so there is no file name to show in examples
+	filename := path
+	if filename == "-" {
+		filename = ""
+	}
+
+	options := []tea.ProgramOption{
+		tea.WithAltScreen(),
+		tea.WithMouseCellMotion(),
+	}
 
-	if isStdinAvailable() {
-		input, err = io.ReadAll(os.Stdin)
+	// Keep stdout free for the selection: draw the UI on the terminal itself
+	if !isTerminal(os.Stdout) {
+		tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error: cannot open terminal for the UI: %v\n", err)
+			os.Exit(exitError)
 		}
-	} else {
-		fmt.Fprintf(os.Stderr, "Error: No input provided. Use: cat file.json | jqpick [filename]\n")
-		os.Exit(1)
-	}
+		defer tty.Close()
+		options = append(options, tea.WithOutput(tty))
 
-	jsonData, err := parseJSON(input)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing JSON: %v\n", err)
-		os.Exit(1)
+		// Colors depend on the terminal, not on stdout. The styles made at
+		// startup hold the previous renderer, so it gets the profile too.
+		renderer := lipgloss.NewRenderer(tty)
+		lipgloss.SetColorProfile(renderer.ColorProfile())
+		lipgloss.SetDefaultRenderer(renderer)
 	}
 
-	root := buildJSONTree(jsonData, nil, "")
+	// Saves made while the file first loads are reloaded by the first check
+	var stamp fileStamp
+	if watch {
+		stamp = stampFile(path)
+	}
 
 	p := tea.NewProgram(
 		model{
-			root:     root,
-			cursor:   0,
-			filename: filename,
+			inputPath:  path,
+			options:    opts,
+			loading:    true,
+			follow:     follow,
+			autoScroll: follow,
+			watch:      watch,
+			watchStamp: stamp,
+			command:    command,
+			interval:   interval,
+			cursor:     0,
+			sortKeys:   sortKeys,
+			filename:   filename,
+			printMode:  printMode,
+			printKind:  kind,
 		},
-		tea.WithAltScreen(),
-		tea.WithMouseCellMotion(),
+		options...,
 	)
 
-	if _, err := p.Run(); err != nil {
+	final, err := p.Run()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error running program: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitError)
+	}
+
+	m := final.(model)
+	m.doc.Close()
+	if m.loadErr != nil {
+		fmt.Fprintf(os.Stderr, "Error %v\n", m.loadErr)
+	}
+	if m.accepted {
+		fmt.Println(m.output)
 	}
+	if code := m.exitCode(); code != exitSelected {
+		os.Exit(code)
+	}
+}
+
+// exitCode is how jqpick exits once the UI has closed
+func (m model) exitCode() int {
+	switch {
+	case m.loadErr != nil:
+		return exitError
+	case m.printMode && !m.accepted:
+		return exitCancelled
+	default:
+		return exitSelected
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && (stat.Mode()&os.ModeCharDevice) != 0
 }
 
 func isStdinAvailable() bool {
@@ -105,44 +308,54 @@ func isStdinAvailable() bool {
 	return (stat.Mode() & os.ModeCharDevice) == 0
 }
 
-// parseJSON tries to parse as regular JSON first, then as JSON Lines (NDJSON)
+// parseJSON parses a single JSON value, or a stream of values such as JSON
+// Lines or concatenated objects, which is returned as an array
 func parseJSON(input []byte) (interface{}, error) {
-	// Try regular JSON first
-	var jsonData interface{}
-	if err := json.Unmarshal(input, &jsonData); err == nil {
-		return jsonData, nil
-	}
-
-	// Try JSON Lines (newline-delimited JSON)
-	var objects []interface{}
-	scanner := bufio.NewScanner(bytes.NewReader(input))
-	lineNum := 0
-	for scanner.Scan() {
-		lineNum++
-		line := bytes.TrimSpace(scanner.Bytes())
-		if len(line) == 0 {
-			continue // Skip empty lines
-		}
-		var obj interface{}
-		if err := json.Unmarshal(line, &obj); err != nil {
-			return nil, fmt.Errorf("line %d: %v", lineNum, err)
-		}
-		objects = append(objects, obj)
+	parsed, err := readJSONInput(input)
+	if err != nil {
+		return nil, err
 	}
+	return parsed.value, nil
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+// jsonInput is a parsed JSON input together with where its records came from
+type jsonInput struct {
+	value   interface{}
+	lines   []int       // line each record starts on when value is a stream
+	skipped []lineError // lines of a JSON Lines input that were not valid JSON
+}
+
+// readJSONInput parses input as a single JSON value or a stream of values.
+// When the stream is broken but its first line and most others are JSON
+// values of their own, it is read as JSON Lines and the bad lines are
+// skipped.
+func readJSONInput(input []byte) (*jsonInput, error) {
+	// Try regular JSON first
+	if jsonData, err := decodeJSON(input); err == nil {
+		return &jsonInput{value: jsonData}, nil
 	}
 
-	if len(objects) == 0 {
-		return nil, fmt.Errorf("no valid JSON found")
+	values, lines, err := decodeJSONStream(input)
+	if err != nil {
+		// A broken document is not worth taking apart line by line
+		if !firstLineIsValue(input) {
+			return nil, err
+		}
+		records, recordLines, skipped, linesErr := decodeJSONLines(input)
+		if linesErr != nil || len(skipped) >= len(records) {
+			return nil, err
+		}
+		return &jsonInput{value: records, lines: recordLines, skipped: skipped}, nil
 	}
 
-	// Return as array if multiple objects, single object if just one
-	if len(objects) == 1 {
-		return objects[0], nil
+	switch len(values) {
+	case 0:
+		return nil, fmt.Errorf("no valid JSON found")
+	case 1:
+		return &jsonInput{value: values[0]}, nil
+	default:
+		return &jsonInput{value: values, lines: lines}, nil
 	}
-	return objects, nil
 }
 
 func printVersion() {
@@ -157,14 +370,49 @@ func printHelp() {
 Version: %s
 
 Usage:
-  cat file.json | jqpick [filename] [options]
+  jqpick [options] [file]
+  cat file.json | jqpick [options] [-]
+  jqpick [options] -- command [args...]
+  jq "$(jqpick file.json)" file.json
 
 Arguments:
-  filename       Optional filename to display in examples
+  file             JSON file to open; "-" or no file reads stdin
+  command          Command whose output to open; r runs it again
 
 Options:
-  -h, --help     Show this help message
-  -v, --version  Show version information
+  -S, --sort-keys  Show object keys sorted alphabetically
+      --yaml       Read the input as YAML (detected for .yaml/.yml files
+                   and for input that is not JSON)
+      --csv        Read the input as CSV with a header row (detected for .csv)
+      --tsv        Read the input as TSV with a header row (detected for .tsv)
+      --infer-types
+                   Give CSV/TSV cells number and boolean types and turn
+                   empty cells into null
+      --jsonc      Read the input as JSON with comments and trailing commas
+                   (detected for .jsonc/.json5 files and for JSON that
+                   fails to parse only because of them)
+  -f, --follow     Keep reading a growing JSON Lines file or stream, like
+                   tail -f, appending new records to the tree
+  -w, --watch      Reload the file when it changes, keeping the cursor and
+                   selection and highlighting what changed
+  -n, --interval seconds
+                   Run the command again every so many seconds, like
+                   watch -n (a duration such as 1m30s also works)
+  -p, --print      Print the query of the confirmed node to stdout
+                   (the default when stdout is not a terminal)
+  -r, --raw-output Print the confirmed value instead, strings unquoted
+  -c, --compact-output
+                   Print the confirmed value as compact JSON
+  -h, --help       Show this help message
+  -v, --version    Show version information
+
+Print Mode:
+  The UI is drawn on the terminal and Enter prints the query (or value) of
+  the node under the cursor to stdout, then exits. The exit status is 0 when
+  a selection was printed and 130 when jqpick was quit without one.
+  JSON Lines and other streams of values are shown as one array, so their
+  queries, like .[1].id, assume jq reads them slurped with -s:
+  jq -s "$(jqpick data.jsonl)" data.jsonl
 
 Interactive Controls:
   ↑/k     Move cursor up
@@ -172,19 +420,67 @@ Interactive Controls:
   ←/h     Collapse current node
   →/l     Expand current node
   Enter   Select current node and show jq query
+  y       Copy query to clipboard in the active path format
+  p       Cycle path format (jq, JSONPath, JSON Pointer, JMESPath)
+  c       Copy as: pick a path format, Python, JavaScript, Go gjson or yq
+  Y       Copy selected value as compact JSON
+  *       Cycle wildcard forms of the path over all array elements
+  m       Mark fields for an object-construction query
+  f       Filter the enclosing array by the value under the cursor
+  o       Change the filter operator (== != < > contains test)
   Space   Toggle expand/collapse
+  s       Toggle sorted/document key order
+  r       Run the command again (with -- command)
+  F       Toggle auto-scroll to the newest record (with -f)
   /       Search (start typing)
-  Esc     Clear search/selection
+  :       Evaluate a jq filter with the embedded jq engine
+  Esc     Clear filter, marks or selection
   q       Quit
 
 Supported Formats:
   - Standard JSON
-  - JSON Lines (NDJSON) - one JSON object per line
+  - JSON Lines (NDJSON) - one JSON object per line; invalid lines are
+    skipped and counted
+  - Concatenated JSON values, like jq's own output, shown as an array
+  - JSONC and JSON5 - comments are shown next to the values they annotate
+  - YAML, including multi-document streams shown as an array; anchors,
+    aliases and << merge keys are expanded
+  - CSV and TSV - an array with one object per row, keyed by the header
+  - Any of these compressed with gzip, zstd or bzip2, from files or stdin
+
+Inputs of 64 MiB or more are loaded lazily: nested values are read from the
+file only when expanded. Compressed input is decompressed to a temporary
+file for that.
+
+Input that fails to parse is shown around the error, with its line and
+column; Enter then explores what was read before the error.
+
+Strings holding serialized JSON objects or arrays expand like containers
+into the decoded value, which queries read with fromjson, as in
+.payload | fromjson | .id. Base64 text expands into the decoded text
+(@base64d), and a JWT into its header and claims, with exp, iat and nbf
+shown as dates. Only jq can express such queries.
+
+With --watch, and when a command runs again, values that changed since the
+previous version are shown with their old value, new ones with a +, and
+removed ones struck through in their old place until the next change.
 
 Examples:
+  jqpick api.json
+  jq "$(jqpick api.json)" api.json     # pick a path, run it with jq
+  ID=$(jqpick -r < api.json)           # pick a value
   cat api.json | jqpick
   cat data.jsonl | jqpick              # JSON Lines
+  jq -s "$(jqpick data.jsonl)" data.jsonl   # streams are queried slurped
+  jq '.items[]' api.json | jqpick      # a stream of values
+  kubectl get pods -o yaml | jqpick    # YAML
+  jqpick -f app.log.ndjson             # follow a log as it is written
+  kubectl logs -f deploy/api | jqpick -f
+  jqpick -w config.json                # see edits as they are saved
+  jqpick -- kubectl get pods -o json   # r runs kubectl again
+  jqpick -n 5 -- curl -s https://api.example.com/status
   echo '{"users":[{"name":"John"}]}' | jqpick
   curl -s https://api.example.com/data | jqpick
+  aws ec2 describe-instances --output json | jqpick   # p for JMESPath
 `, Version)
 }
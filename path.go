@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pathSegment is one step from a node's parent down to the node
+type pathSegment struct {
+	Key     string // object key
+	Index   int    // array index when IsIndex is set
+	IsIndex bool
+	Iterate bool   // every element of an array, as in .users[]
+	Decode  string // jq filter decoding the string reached so far, as in .payload | fromjson
+}
+
+// path returns the segments leading from the root to n
+func (n *JSONNode) path() []pathSegment {
+	var segs []pathSegment
+	for current := n; current.Parent != nil; current = current.Parent {
+		seg := pathSegment{Key: current.Key}
+		if current.decoded() {
+			seg.Decode = current.decodeFilter()
+		}
+		if current.Parent.Type == "array" {
+			seg.IsIndex = true
+			seg.Index, _ = strconv.Atoi(current.Key)
+		}
+		segs = append(segs, seg)
+	}
+
+	// Segments were collected leaf first
+	for i, j := 0, len(segs)-1; i < j; i, j = i+1, j-1 {
+		segs[i], segs[j] = segs[j], segs[i]
+	}
+	return segs
+}
+
+// formatJqPath renders segments as a jq path expression. Keys that are not
+// plain identifiers use the ["..."] form, which every jq version accepts.
+// Decoding a string pipes it through the filter, .payload | fromjson | .id.
+func formatJqPath(segs []pathSegment) string {
+	if len(segs) == 0 {
+		return "."
+	}
+
+	var b strings.Builder
+	start := true // the next segment begins a path
+	for _, seg := range segs {
+		if seg.Decode != "" {
+			if b.Len() > 0 {
+				b.WriteString(" | ")
+			}
+			b.WriteString(seg.Decode)
+			start = true
+			continue
+		}
+		if start && b.Len() > 0 {
+			b.WriteString(" | ")
+		}
+		switch {
+		case seg.Iterate:
+			if start {
+				b.WriteByte('.')
+			}
+			b.WriteString("[]")
+		case seg.IsIndex:
+			if start {
+				b.WriteByte('.')
+			}
+			b.WriteString("[" + strconv.Itoa(seg.Index) + "]")
+		case isJqIdentifier(seg.Key):
+			b.WriteString("." + seg.Key)
+		default:
+			if start {
+				b.WriteByte('.')
+			}
+			b.WriteString("[" + quoteJSONString(seg.Key) + "]")
+		}
+		start = false
+	}
+	return b.String()
+}
+
+// Ways a generalized path can be written in jq
+const (
+	wildcardStream  = iota // .users[].name
+	wildcardCollect        // [.users[].name]
+	wildcardMap            // .users | map(.name)
+	wildcardForms
+)
+
+// generalizePath replaces every array index with an iterator
+func generalizePath(segs []pathSegment) []pathSegment {
+	general := make([]pathSegment, len(segs))
+	for i, seg := range segs {
+		if seg.IsIndex {
+			seg = pathSegment{Iterate: true}
+		}
+		general[i] = seg
+	}
+	return general
+}
+
+// hasIndex reports whether any segment addresses an array element
+func hasIndex(segs []pathSegment) bool {
+	for _, seg := range segs {
+		if seg.IsIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// formatJqWildcard renders a generalized path in one of the wildcard forms.
+// The map form splits at the first iterator.
+func formatJqWildcard(segs []pathSegment, form int) string {
+	switch form {
+	case wildcardCollect:
+		return "[" + formatJqPath(segs) + "]"
+	case wildcardMap:
+		for i, seg := range segs {
+			if !seg.Iterate {
+				continue
+			}
+			mapped := "map(" + formatJqPath(segs[i+1:]) + ")"
+			if i == 0 {
+				return mapped
+			}
+			return formatJqPath(segs[:i]) + " | " + mapped
+		}
+	}
+	return formatJqPath(segs)
+}
+
+// projectionField is one key of an object-construction query
+type projectionField struct {
+	Name string
+	Path []pathSegment // relative to the projection base
+}
+
+// buildProjection splits paths into a shared base and one field per path.
+// Array indices in the shared prefix become iterators, and the base ends at
+// the deepest such iterator so every field is read from the same element.
+func buildProjection(paths [][]pathSegment) ([]pathSegment, []projectionField) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	general := make([][]pathSegment, len(paths))
+	shortest := len(paths[0])
+	for i, segs := range paths {
+		general[i] = generalizePath(segs)
+		if len(segs) < shortest {
+			shortest = len(segs)
+		}
+	}
+
+	// Every field keeps at least its own last segment
+	common := 0
+	for common < shortest-1 && sameSegmentInAll(general, common) {
+		common++
+	}
+
+	baseLen := common
+	for i := common - 1; i >= 0; i-- {
+		if general[0][i].Iterate {
+			baseLen = i + 1
+			break
+		}
+	}
+
+	used := make(map[string]bool)
+	fields := make([]projectionField, len(paths))
+	for i, segs := range paths {
+		rel := segs[baseLen:]
+		name := fieldName(rel, false)
+		if used[name] {
+			name = fieldName(rel, true)
+		}
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s_%d", fieldName(rel, true), n)
+		}
+		used[name] = true
+		fields[i] = projectionField{Name: name, Path: rel}
+	}
+	return general[0][:baseLen], fields
+}
+
+func sameSegmentInAll(paths [][]pathSegment, i int) bool {
+	for _, segs := range paths[1:] {
+		if segs[i] != paths[0][i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldName names a projected value after its last key, or after all of its
+// keys joined with underscores when long is set
+func fieldName(rel []pathSegment, long bool) string {
+	var keys []string
+	for _, seg := range rel {
+		if !seg.IsIndex && !seg.Iterate && seg.Decode == "" {
+			keys = append(keys, seg.Key)
+		}
+	}
+	switch {
+	case len(keys) == 0:
+		return "value"
+	case long:
+		return strings.Join(keys, "_")
+	default:
+		return keys[len(keys)-1]
+	}
+}
+
+// formatJqProjection renders base | {field, other: .path, ...}
+func formatJqProjection(base []pathSegment, fields []projectionField) string {
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		shorthand := len(field.Path) == 1 && !field.Path[0].IsIndex && field.Path[0].Key == field.Name
+		switch {
+		case shorthand && isJqIdentifier(field.Name):
+			parts[i] = field.Name
+		case isJqIdentifier(field.Name):
+			parts[i] = field.Name + ": " + formatJqPath(field.Path)
+		default:
+			parts[i] = quoteJSONString(field.Name) + ": " + formatJqPath(field.Path)
+		}
+	}
+
+	object := "{" + strings.Join(parts, ", ") + "}"
+	if len(base) == 0 {
+		return object
+	}
+	return formatJqPath(base) + " | " + object
+}
+
+// predicateOps are the comparisons offered by the predicate builder
+var predicateOps = []string{"==", "!=", "<", ">", "contains", "test"}
+
+// predicate selects the elements of an array whose field compares to a value
+type predicate struct {
+	Base    []pathSegment // elements being filtered, ending in an iterator
+	Field   []pathSegment // compared value, relative to each element
+	Op      string
+	Value   interface{} // scalar the field is compared with
+	Literal string      // Value as JSON text
+}
+
+// buildPredicate filters the nearest array containing the node at segs by
+// the node's own value
+func buildPredicate(segs []pathSegment, value interface{}, literal string, op string) predicate {
+	p := predicate{Field: segs, Op: op, Value: value, Literal: literal}
+	for i := len(segs) - 1; i >= 0; i-- {
+		if segs[i].IsIndex {
+			p.Base = append(append([]pathSegment{}, segs[:i]...), pathSegment{Iterate: true})
+			p.Field = segs[i+1:]
+			break
+		}
+	}
+	return p
+}
+
+// formatJqPredicate renders base | select(field OP value)
+func formatJqPredicate(p predicate) string {
+	field := formatJqPath(p.Field)
+	var cond string
+	switch p.Op {
+	case "contains":
+		cond = field + " | contains(" + p.Literal + ")"
+	case "test":
+		str, isString := p.Value.(string)
+		if !isString {
+			str = p.Literal
+			field += " | tostring"
+		}
+		cond = field + " | test(" + quoteJSONString(regexp.QuoteMeta(str)) + ")"
+	default:
+		cond = field + " " + p.Op + " " + p.Literal
+	}
+
+	selectExpr := "select(" + cond + ")"
+	if len(p.Base) == 0 {
+		return selectExpr
+	}
+	return formatJqPath(p.Base) + " | " + selectExpr
+}
+
+// jqKeywords cannot follow a dot in older jq releases, so they are quoted
+var jqKeywords = map[string]bool{
+	"and": true, "as": true, "catch": true, "def": true, "elif": true,
+	"else": true, "end": true, "foreach": true, "if": true, "import": true,
+	"include": true, "label": true, "not": true, "or": true, "reduce": true,
+	"then": true, "try": true, "__loc__": true,
+}
+
+// isJqIdentifier reports whether key can be written as .key in jq
+func isJqIdentifier(key string) bool {
+	if key == "" || jqKeywords[key] {
+		return false
+	}
+	for i, r := range key {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// quoteJSONString returns s as a JSON string literal without HTML escaping
+func quoteJSONString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// queryKind is the kind of jq query built from the selected node
+type queryKind int
+
+const (
+	queryPath       queryKind = iota // exact path to the node
+	queryWildcard                    // path with array indices turned into iterators
+	queryProjection                  // object built from the marked nodes
+	queryPredicate                   // select() on the node's value
+)
+
+// selectNode makes node the selection and shows its exact path
+func (m *model) selectNode(node *JSONNode) {
+	m.selected = node
+	m.queryKind = queryPath
+	m.refreshQuery()
+}
+
+// toggleMark adds node to or removes it from the marked nodes. While nodes
+// are marked the query section shows the object-construction query.
+func (m *model) toggleMark(node *JSONNode) {
+	for i, marked := range m.marked {
+		if marked == node {
+			m.marked = append(m.marked[:i], m.marked[i+1:]...)
+			m.refreshQuery()
+			return
+		}
+	}
+	m.marked = append(m.marked, node)
+	m.refreshQuery()
+}
+
+func (m model) isMarked(node *JSONNode) bool {
+	for _, marked := range m.marked {
+		if marked == node {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *model) clearMarks() {
+	m.marked = nil
+	m.refreshQuery()
+}
+
+// cycleWildcard steps through the wildcard forms of the selected path and
+// back to the exact path
+func (m *model) cycleWildcard() {
+	if m.selected == nil {
+		return
+	}
+	if len(m.marked) > 0 {
+		m.status = "Clear the marked fields (esc) to generalize a single path"
+		return
+	}
+	if !hasIndex(m.selected.path()) {
+		m.status = "No array index in the selected path to generalize"
+		return
+	}
+
+	switch {
+	case m.queryKind != queryWildcard:
+		m.queryKind = queryWildcard
+		m.wildcardForm = wildcardStream
+	case m.wildcardForm+1 < pathFormats[m.format].WildcardForms:
+		m.wildcardForm++
+	default:
+		m.queryKind = queryPath
+	}
+	m.refreshQuery()
+}
+
+// cycleFormat switches the query section to the next path format
+func (m *model) cycleFormat() {
+	m.format = (m.format + 1) % len(pathFormats)
+	if m.wildcardForm >= pathFormats[m.format].WildcardForms {
+		m.wildcardForm = wildcardStream
+	}
+	m.refreshQuery()
+	m.status = "Path format: " + pathFormats[m.format].Name
+}
+
+// startPredicate selects node and filters its array by the node's value
+func (m *model) startPredicate(node *JSONNode) {
+	if node.isContainer() {
+		m.status = "Predicates compare scalar values; move to a string, number, boolean or null"
+		return
+	}
+	if len(m.marked) > 0 {
+		m.status = "Clear the marked fields (esc) to build a predicate"
+		return
+	}
+	m.selected = node
+	m.queryKind = queryPredicate
+	m.predicateOp = 0
+	m.refreshQuery()
+}
+
+// cyclePredicateOp switches the predicate to the next comparison operator
+func (m *model) cyclePredicateOp() {
+	if m.queryKind != queryPredicate {
+		return
+	}
+	for {
+		m.predicateOp = (m.predicateOp + 1) % len(predicateOps)
+		if predicateApplies(predicateOps[m.predicateOp], m.selected) {
+			break
+		}
+	}
+	m.refreshQuery()
+}
+
+// predicateApplies reports whether op can compare node's value. jq only
+// checks containment of strings, arrays and objects, so contains is left
+// out for other scalars; test reads them as text.
+func predicateApplies(op string, node *JSONNode) bool {
+	return op != "contains" || node.Type == "string"
+}
+
+// applicablePredicateOps lists the operators the selection can be compared
+// with
+func (m model) applicablePredicateOps() []string {
+	var ops []string
+	for _, op := range predicateOps {
+		if predicateApplies(op, m.selected) {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// refreshQuery rebuilds the query, its jq form and its note from the
+// selection
+func (m *model) refreshQuery() {
+	m.queryNote = ""
+	m.countSeq++
+	switch {
+	case len(m.marked) > 0:
+		m.queryKind = queryProjection
+	case m.queryKind == queryProjection:
+		m.queryKind = queryPath
+	}
+	if !m.hasQuery() {
+		m.query, m.jqQuery, m.queryErr = "", "", ""
+		return
+	}
+
+	// Notes describe the jq form, which the embedded engine can run
+	m.jqQuery, _ = m.renderQuery(jqFormat)
+	m.query, m.queryErr = "", ""
+	if query, err := m.renderQuery(pathFormats[m.format]); err != nil {
+		m.queryErr = err.Error()
+	} else {
+		m.query = query
+	}
+
+	// Counting would read every value of a lazily indexed input
+	lazy := m.doc != nil && m.doc.lazy
+	switch m.queryKind {
+	case queryProjection:
+		if lazy {
+			break
+		}
+		base, fields := buildProjection(m.markedPaths())
+		if count, err := m.root.countPathValues(base); err == nil {
+			m.queryNote = fmt.Sprintf("Builds %d objects from %d marked fields", count, len(fields))
+		}
+	case queryWildcard:
+		if lazy {
+			break
+		}
+		count, err := m.root.countPathValues(generalizePath(m.selected.path()))
+		switch {
+		case err != nil:
+			m.queryNote = fmt.Sprintf("Fails on this input: %v", err)
+		case m.wildcardForm == wildcardStream:
+			m.queryNote = fmt.Sprintf("Yields %d values", count)
+		default:
+			m.queryNote = fmt.Sprintf("Collects %d values into an array", count)
+		}
+	case queryPredicate:
+		if m.jqQuery == "" {
+			return
+		}
+		// The matches are counted by countMatchesCmd once the update is done
+		m.queryNote = m.predicateHelp()
+	}
+}
+
+// predicateHelp explains how to change the predicate's operator
+func (m model) predicateHelp() string {
+	return "o change operator (" + strings.Join(m.applicablePredicateOps(), " ") + ")"
+}
+
+// hasQuery reports whether there is a selection or marks to build a query
+// from
+func (m model) hasQuery() bool {
+	return m.selected != nil || len(m.marked) > 0
+}
+
+// renderQuery renders the query being built in format f
+func (m model) renderQuery(f pathFormat) (string, error) {
+	if !f.Decodes && m.decodesString(m.selected) {
+		return "", f.unsupported("a value decoded from a string")
+	}
+	switch m.queryKind {
+	case queryProjection:
+		if f.Projection == nil {
+			return "", f.unsupported("object construction")
+		}
+		base, fields := buildProjection(m.markedPaths())
+		return f.Projection(base, fields)
+	case queryWildcard:
+		if f.Wildcard == nil {
+			return "", f.unsupported("a path over all array elements")
+		}
+		return f.Wildcard(generalizePath(m.selected.path()), m.wildcardForm)
+	case queryPredicate:
+		if f.Predicate == nil {
+			return "", f.unsupported("a filter")
+		}
+		literal, err := m.selected.jsonLiteral()
+		if err != nil {
+			return "", err
+		}
+		return f.Predicate(buildPredicate(m.selected.path(), m.selected.Value, literal, predicateOps[m.predicateOp]))
+	default:
+		return f.Path(m.selected.path())
+	}
+}
+
+// queryFor renders the query being built in format f when node is the
+// selection, or node's own path otherwise
+func (m model) queryFor(node *JSONNode, f pathFormat) (string, error) {
+	if node != m.selected && len(m.marked) == 0 {
+		if !f.Decodes && m.decodesString(node) {
+			return "", f.unsupported("a value decoded from a string")
+		}
+		return f.Path(node.path())
+	}
+	return m.renderQuery(f)
+}
+
+// yq returns the yq format matching the input's document layout
+func (m model) yq() pathFormat {
+	if m.doc != nil && m.doc.stream {
+		return yqStreamFormat
+	}
+	return yqFormat
+}
+
+// copyFormats lists every format the copy menu offers
+func (m model) copyFormats() []pathFormat {
+	formats := append(append([]pathFormat{}, pathFormats...), codeFormats...)
+	for i, f := range formats {
+		if f.Name == yqFormat.Name {
+			formats[i] = m.yq()
+		}
+	}
+	return formats
+}
+
+func (m model) markedPaths() [][]pathSegment {
+	paths := make([][]pathSegment, len(m.marked))
+	for i, node := range m.marked {
+		paths[i] = node.path()
+	}
+	return paths
+}
+
+// countResultMsg carries the number of values a predicate matches. Seq
+// identifies the query it was counted for so stale counts are dropped.
+type countResultMsg struct {
+	seq   int
+	count int
+	err   error
+}
+
+// countMatchesCmd counts the values the predicate matches with the embedded
+// jq engine in the background. Lazily indexed inputs are not decoded for
+// this.
+func (m *model) countMatchesCmd() tea.Cmd {
+	if m.queryKind != queryPredicate || m.jqQuery == "" || m.doc != nil && m.doc.lazy {
+		return nil
+	}
+	doc, err := m.document()
+	if err != nil {
+		m.queryNote = fmt.Sprintf("Fails on this input: %v • %s", err, m.predicateHelp())
+		return nil
+	}
+	seq, query := m.countSeq, m.jqQuery
+	return func() tea.Msg {
+		count, err := countResults(query, doc)
+		return countResultMsg{seq: seq, count: count, err: err}
+	}
+}
+
+// countResults runs query on doc and counts its outputs
+func countResults(query string, doc interface{}) (int, error) {
+	result, err := evalFilter("["+query+"] | length", doc)
+	if err != nil {
+		return 0, err
+	}
+	count, ok := result.Value.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("unexpected result %s", result.getValuePreview())
+	}
+	n, err := count.Int64()
+	return int(n), err
+}
+
+// queryTitle names the query shown in the query section
+func (m model) queryTitle() string {
+	title := "JQ Query"
+	if f := pathFormats[m.format]; f.Name != jqFormat.Name {
+		title = f.Name
+	}
+	switch m.queryKind {
+	case queryWildcard:
+		return title + " (all array elements)"
+	case queryProjection:
+		return title + " (marked fields)"
+	case queryPredicate:
+		return title + " (filter by value)"
+	default:
+		return title
+	}
+}
+
+// accept confirms node in print mode and prepares what is printed to stdout
+// once the UI exits. The query printed is the one being built when node is
+// the selection, or node's own path otherwise, in the active path format.
+func (m *model) accept(node *JSONNode) error {
+	var output string
+	switch m.printKind {
+	case printRaw:
+		if str, ok := node.Value.(string); ok && node.Type == "string" {
+			output = str
+			break
+		}
+		fallthrough
+	case printCompact:
+		value, err := node.compactJSON()
+		if err != nil {
+			return err
+		}
+		output = value
+	default:
+		query, err := m.queryFor(node, pathFormats[m.format])
+		if err != nil {
+			return err
+		}
+		output = query
+	}
+
+	m.output = output
+	m.accepted = true
+	return nil
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -16,21 +19,40 @@ func buildJSONTree(data interface{}, parent *JSONNode, key string) *JSONNode {
 	}
 
 	switch v := data.(type) {
+	case orderedObject:
+		node.Type = "object"
+		for i, field := range v {
+			child := buildJSONTree(field.Value, node, field.Key)
+			child.Index = i
+			node.Children = append(node.Children, child)
+		}
 	case map[string]interface{}:
+		// Plain maps have no document order, so use the order jq prints
 		node.Type = "object"
-		for k, val := range v {
-			child := buildJSONTree(val, node, k)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for i, k := range keys {
+			child := buildJSONTree(v[k], node, k)
+			child.Index = i
 			node.Children = append(node.Children, child)
 		}
 	case []interface{}:
 		node.Type = "array"
 		for i, val := range v {
 			child := buildJSONTree(val, node, strconv.Itoa(i))
+			child.Index = i
 			node.Children = append(node.Children, child)
 		}
 	case string:
 		node.Type = "string"
-	case float64:
+		// An embedded value is decoded into a child when first expanded
+		if node.Embed = detectEmbedding(v); node.Embed != nil {
+			node.Expanded = false
+		}
+	case json.Number, float64:
 		node.Type = "number"
 	case bool:
 		node.Type = "boolean"
@@ -41,7 +63,26 @@ func buildJSONTree(data interface{}, parent *JSONNode, key string) *JSONNode {
 	return node
 }
 
+// sortChildren orders object keys alphabetically when sorted is true, or
+// restores document order otherwise. Arrays always keep their order.
+func (n *JSONNode) sortChildren(sorted bool) {
+	if n.Type == "object" {
+		sort.SliceStable(n.Children, func(i, j int) bool {
+			if sorted {
+				return n.Children[i].Key < n.Children[j].Key
+			}
+			return n.Children[i].Index < n.Children[j].Index
+		})
+	}
+	for _, child := range n.Children {
+		child.sortChildren(sorted)
+	}
+}
+
 func (n *JSONNode) getDisplayName() string {
+	if n.decoded() {
+		return n.Key
+	}
 	if n.Parent != nil && n.Parent.Type == "array" {
 		return fmt.Sprintf("[%s]", n.Key)
 	}
@@ -57,20 +98,123 @@ func (n *JSONNode) getValuePreview() string {
 		}
 		return fmt.Sprintf("\"%s\"", str)
 	case "number":
+		// json.Number keeps the literal exactly as written in the input
 		return fmt.Sprintf("%v", n.Value)
 	case "boolean":
 		return fmt.Sprintf("%v", n.Value)
 	case "null":
 		return "null"
 	case "object":
-		return fmt.Sprintf("{...} (%d keys)", len(n.Children))
+		return fmt.Sprintf("{...} (%d keys)", n.childCount())
 	case "array":
-		return fmt.Sprintf("[...] (%d items)", len(n.Children))
+		return fmt.Sprintf("[...] (%d items)", n.childCount())
 	default:
 		return fmt.Sprintf("%v", n.Value)
 	}
 }
 
+func (n *JSONNode) isContainer() bool {
+	return n.Type == "object" || n.Type == "array"
+}
+
+// childCount is the number of children, including ones that have not been
+// loaded from the input yet
+func (n *JSONNode) childCount() int {
+	if n.Span != nil && !n.Span.loaded {
+		return n.Count
+	}
+	return len(n.Children)
+}
+
+// setExpanded expands or collapses a container, reading lazily indexed
+// children from the input, or decoding an embedded value, on first expansion
+func (n *JSONNode) setExpanded(expanded bool) error {
+	if !n.expandable() {
+		return nil
+	}
+	if expanded {
+		if err := n.loadChildren(); err != nil {
+			return err
+		}
+	}
+	n.Expanded = expanded
+	return nil
+}
+
+// value returns the node's JSON value, decoding it from the input for
+// lazily indexed containers
+func (n *JSONNode) value() (interface{}, error) {
+	if n.Span != nil {
+		raw, err := n.Span.rawJSON()
+		if err != nil {
+			return nil, err
+		}
+		return decodeJSON(raw)
+	}
+	if n.Type == "array" && n.Value == nil {
+		// Synthetic root holding the records of a lazily indexed stream
+		values := make([]interface{}, len(n.Children))
+		for i, child := range n.Children {
+			v, err := child.value()
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+	}
+	return n.Value, nil
+}
+
+// losesPrecision reports whether the node is a number that cannot survive a
+// round trip through a double, as jq would read it
+func (n *JSONNode) losesPrecision() bool {
+	num, ok := n.Value.(json.Number)
+	return ok && numberLosesPrecision(num)
+}
+
+// compactJSON renders the node's value as compact JSON, keeping key order and
+// number literals from the input
+func (n *JSONNode) compactJSON() (string, error) {
+	if n.Span != nil {
+		raw, err := n.Span.rawJSON()
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, raw); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	if n.Type == "array" && n.Value == nil {
+		// Synthetic root holding the records of a lazily indexed stream
+		records := make([]string, len(n.Children))
+		for i, child := range n.Children {
+			record, err := child.compactJSON()
+			if err != nil {
+				return "", err
+			}
+			records[i] = record
+		}
+		return "[" + strings.Join(records, ",") + "]", nil
+	}
+	data, err := marshalJSON(n.Value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// jsonLiteral renders the node's value as it would be written in a jq
+// program: strings without HTML escaping, other values as compact JSON
+func (n *JSONNode) jsonLiteral() (string, error) {
+	if str, ok := n.Value.(string); ok && n.Type == "string" {
+		return quoteJSONString(str), nil
+	}
+	return n.compactJSON()
+}
+
 func (n *JSONNode) getAllVisibleNodes() []*JSONNode {
 	var nodes []*JSONNode
 	var collectNodes func(*JSONNode)
@@ -81,6 +225,9 @@ func (n *JSONNode) getAllVisibleNodes() []*JSONNode {
 			for _, child := range node.Children {
 				collectNodes(child)
 			}
+			for _, child := range node.Removed {
+				collectNodes(child)
+			}
 		}
 	}
 
@@ -113,40 +260,94 @@ func (n *JSONNode) matchesSearch(term string) bool {
 	return false
 }
 
+// buildJqQuery returns the jq path from the root to n
 func (n *JSONNode) buildJqQuery() string {
-	if n.Parent == nil {
-		return "."
-	}
+	return formatJqPath(n.path())
+}
 
-	// Build the path from root to this node
-	var pathParts []string
-	current := n
+// countPathValues counts the values jq yields for segs on the tree rooted at
+// n, following jq's rules: missing keys and indices yield null, while
+// iterating or indexing a value of the wrong type is an error
+func (n *JSONNode) countPathValues(segs []pathSegment) (int, error) {
+	if len(segs) == 0 {
+		return 1, nil
+	}
+	seg, rest := segs[0], segs[1:]
 
-	for current.Parent != nil {
-		if current.Parent.Type == "array" {
-			pathParts = append([]string{fmt.Sprintf("[%s]", current.Key)}, pathParts...)
-		} else {
-			pathParts = append([]string{current.Key}, pathParts...)
+	if n == nil || n.Type == "null" {
+		if seg.Iterate {
+			return 0, fmt.Errorf("cannot iterate over null")
 		}
-		current = current.Parent
+		return (*JSONNode)(nil).countPathValues(rest)
+	}
+	if err := n.loadChildren(); err != nil {
+		return 0, err
 	}
 
-	// Build the query
-	query := "."
-	for _, part := range pathParts {
-		if strings.HasPrefix(part, "[") {
-			// Array access - append directly
-			query += part
-		} else {
-			// Object field access - add dot separator
-			query += "." + part
+	switch {
+	case seg.Decode != "":
+		for _, child := range n.Children {
+			if n.Embed != nil && child.decodeFilter() == seg.Decode {
+				return child.countPathValues(rest)
+			}
+		}
+		return 0, fmt.Errorf("cannot %s a %s", seg.Decode, n.Type)
+	case seg.Iterate:
+		if !n.isContainer() {
+			return 0, fmt.Errorf("cannot iterate over %s", n.Type)
+		}
+		total := 0
+		for _, child := range n.Children {
+			count, err := child.countPathValues(rest)
+			if err != nil {
+				return 0, err
+			}
+			total += count
+		}
+		return total, nil
+	case seg.IsIndex:
+		if n.Type != "array" {
+			return 0, fmt.Errorf("cannot index %s with number", n.Type)
+		}
+		var child *JSONNode
+		if seg.Index < len(n.Children) {
+			child = n.Children[seg.Index]
+		}
+		return child.countPathValues(rest)
+	default:
+		if n.Type != "object" {
+			return 0, fmt.Errorf("cannot index %s with %q", n.Type, seg.Key)
 		}
+		var child *JSONNode
+		for _, c := range n.Children {
+			if c.Key == seg.Key {
+				child = c
+				break
+			}
+		}
+		return child.countPathValues(rest)
 	}
+}
 
-	// Clean up leading double dot for object access
-	if strings.HasPrefix(query, "..") {
-		query = query[1:]
+// attachComments sets the comments of n and its descendants from a map keyed
+// by jq path
+func (n *JSONNode) attachComments(comments map[string]string) {
+	if len(comments) == 0 {
+		return
+	}
+	n.Comment = comments[n.buildJqQuery()]
+	for _, child := range n.Children {
+		child.attachComments(comments)
 	}
+}
 
-	return query
+// record returns the record of a JSON stream that n belongs to, or nil when
+// the input was not a stream with known line numbers
+func (n *JSONNode) record() *JSONNode {
+	for current := n; current.Parent != nil; current = current.Parent {
+		if current.Parent.Parent == nil && current.Line > 0 {
+			return current
+		}
+	}
+	return nil
 }
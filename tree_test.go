@@ -2,9 +2,31 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
 )
 
+func mustBuildTree(t *testing.T, input string) *JSONNode {
+	t.Helper()
+	data, err := parseJSON([]byte(input))
+	if err != nil {
+		t.Fatalf("parseJSON: %v", err)
+	}
+	return buildJSONTree(data, nil, "")
+}
+
+func childKeys(node *JSONNode) []string {
+	var keys []string
+	for _, child := range node.Children {
+		keys = append(keys, child.Key)
+	}
+	return keys
+}
+
 func TestBuildJqQuery(t *testing.T) {
 	testJSON := `{
 		"users": [
@@ -23,9 +45,7 @@ func TestBuildJqQuery(t *testing.T) {
 		}
 	}`
 
-	var data interface{}
-	json.Unmarshal([]byte(testJSON), &data)
-	root := buildJSONTree(data, nil, "")
+	root := mustBuildTree(t, testJSON)
 
 	tests := []struct {
 		name     string
@@ -93,9 +113,7 @@ func TestBuildJqQueryArrayRoot(t *testing.T) {
 	// Test JSON array as root
 	testJSON := `[{"name": "John"}, {"name": "Jane"}]`
 
-	var data interface{}
-	json.Unmarshal([]byte(testJSON), &data)
-	root := buildJSONTree(data, nil, "")
+	root := mustBuildTree(t, testJSON)
 
 	tests := []struct {
 		name     string
@@ -152,9 +170,7 @@ func TestBuildJqQueryArrayRoot(t *testing.T) {
 func TestNodeMatchesSearch(t *testing.T) {
 	testJSON := `{"users": [{"name": "John", "age": 30}]}`
 
-	var data interface{}
-	json.Unmarshal([]byte(testJSON), &data)
-	root := buildJSONTree(data, nil, "")
+	root := mustBuildTree(t, testJSON)
 
 	tests := []struct {
 		name     string
@@ -207,3 +223,718 @@ func TestNodeMatchesSearch(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildJSONTreeKeepsDocumentOrder(t *testing.T) {
+	root := mustBuildTree(t, `{"zeta": 1, "alpha": {"b": 2, "a": 3}, "mid": [{"y": 1, "x": 2}]}`)
+
+	if got := childKeys(root); strings.Join(got, ",") != "zeta,alpha,mid" {
+		t.Errorf("Expected document order zeta,alpha,mid, got %v", got)
+	}
+	if got := childKeys(root.Children[1]); strings.Join(got, ",") != "b,a" {
+		t.Errorf("Expected nested document order b,a, got %v", got)
+	}
+	if q := root.Children[2].Children[0].Children[1].buildJqQuery(); q != ".mid[0].x" {
+		t.Errorf("Expected .mid[0].x, got %s", q)
+	}
+
+	root.sortChildren(true)
+	if got := childKeys(root); strings.Join(got, ",") != "alpha,mid,zeta" {
+		t.Errorf("Expected sorted order alpha,mid,zeta, got %v", got)
+	}
+	if got := childKeys(root.Children[1].Children[0]); strings.Join(got, ",") != "x,y" {
+		t.Errorf("Expected sorted nested order x,y, got %v", got)
+	}
+
+	root.sortChildren(false)
+	if got := childKeys(root); strings.Join(got, ",") != "zeta,alpha,mid" {
+		t.Errorf("Expected document order restored, got %v", got)
+	}
+}
+
+func TestParseJSONDuplicateKeys(t *testing.T) {
+	root := mustBuildTree(t, `{"a": 1, "b": 2, "a": 3}`)
+
+	if got := childKeys(root); strings.Join(got, ",") != "a,b" {
+		t.Fatalf("Expected keys a,b, got %v", got)
+	}
+	if preview := root.Children[0].getValuePreview(); preview != "3" {
+		t.Errorf("Expected last duplicate to win, got %s", preview)
+	}
+}
+
+func TestNumbersKeepOriginalLiteral(t *testing.T) {
+	root := mustBuildTree(t, `{"id": 1234567890123456789, "price": 0.1, "big": 1e400, "small": 42, "exp": 1.5e3}`)
+
+	tests := []struct {
+		key     string
+		preview string
+		lossy   bool
+	}{
+		{"id", "1234567890123456789", true},
+		{"price", "0.1", false},
+		{"big", "1e400", true},
+		{"small", "42", false},
+		{"exp", "1.5e3", false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			node := root.Children[i]
+			if node.Type != "number" {
+				t.Fatalf("Expected number type, got %s", node.Type)
+			}
+			if preview := node.getValuePreview(); preview != tt.preview {
+				t.Errorf("Expected preview %s, got %s", tt.preview, preview)
+			}
+			if lossy := node.losesPrecision(); lossy != tt.lossy {
+				t.Errorf("Expected losesPrecision %v, got %v", tt.lossy, lossy)
+			}
+		})
+	}
+
+	if !root.Children[0].matchesSearch("3456789") {
+		t.Errorf("Expected search to match the exact literal")
+	}
+
+	out, err := root.compactJSON()
+	if err != nil {
+		t.Fatalf("compactJSON: %v", err)
+	}
+	expected := `{"id":1234567890123456789,"price":0.1,"big":1e400,"small":42,"exp":1.5e3}`
+	if out != expected {
+		t.Errorf("Expected %s, got %s", expected, out)
+	}
+}
+
+func TestBuildJqQueryEscapesKeys(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"plain", ".plain"},
+		{"snake_case9", ".snake_case9"},
+		{"_private", "._private"},
+		{"foo-bar", `.["foo-bar"]`},
+		{"a b", `.["a b"]`},
+		{"123", `.["123"]`},
+		{"$ref", `.["$ref"]`},
+		{"@type", `.["@type"]`},
+		{"a.b", `.["a.b"]`},
+		{`say "hi"`, `.["say \"hi\""]`},
+		{`back\slash`, `.["back\\slash"]`},
+		{"", `.[""]`},
+		{"if", `.["if"]`},
+		{"<html>", `.["<html>"]`},
+		{"tab\there", `.["tab\there"]`},
+		{"ключ", `.["ключ"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			root := &JSONNode{Type: "object"}
+			child := &JSONNode{Key: tt.key, Parent: root, Type: "null"}
+			if query := child.buildJqQuery(); query != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, query)
+			}
+		})
+	}
+
+	nested := mustBuildTree(t, `{"a-b": [{"c.d": {"e": 1}}]}`)
+	leaf := nested.Children[0].Children[0].Children[0].Children[0]
+	if query := leaf.buildJqQuery(); query != `.["a-b"][0]["c.d"].e` {
+		t.Errorf("Unexpected nested query %s", query)
+	}
+}
+
+func TestBuildJqQueryRoundTrips(t *testing.T) {
+	input := `{
+		"foo-bar": {"a b": [1, {"$ref": "#/x", "@type": "T"}]},
+		"123": {"a.b": true, "say \"hi\"": null},
+		"if": {"then": "kw", "": "empty"},
+		"nested": [[{"x.y": [0, {"z": "deep"}]}]],
+		"été": "unicode",
+		"tab\tkey": "t"
+	}`
+	data, err := parseJSON([]byte(input))
+	if err != nil {
+		t.Fatalf("parseJSON: %v", err)
+	}
+	root := buildJSONTree(data, nil, "")
+
+	// Each query is run by the jq engine, which rejects keys that need
+	// quoting but are not, such as .foo-bar
+	for _, node := range root.getAllVisibleNodes() {
+		query := node.buildJqQuery()
+		result, err := evalFilter(query, data)
+		if err != nil {
+			t.Errorf("Query %s failed: %v", query, err)
+			continue
+		}
+		// The engine sorts object keys, so the values are compared as maps
+		var got, want interface{}
+		gotJSON, _ := result.compactJSON()
+		wantJSON, _ := node.compactJSON()
+		if err := json.Unmarshal([]byte(gotJSON), &got); err != nil {
+			t.Fatalf("Query %s: %v", query, err)
+		}
+		if err := json.Unmarshal([]byte(wantJSON), &want); err != nil {
+			t.Fatalf("compactJSON: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Query %s resolved to %s, expected %s", query, gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestWildcardQueries(t *testing.T) {
+	root := mustBuildTree(t, `{"users": [
+		{"name": "John", "tags": ["a", "b"], "meta": {"created": "2023"}},
+		{"name": "Jane", "tags": ["c"], "meta": null},
+		{"name": "Joe", "tags": []}
+	]}`)
+
+	tests := []struct {
+		name     string
+		nodePath []int
+		forms    [wildcardForms]string
+		count    int
+	}{
+		{
+			name:     "Field of every element",
+			nodePath: []int{0, 0, 0},
+			forms:    [wildcardForms]string{".users[].name", "[.users[].name]", ".users | map(.name)"},
+			count:    3,
+		},
+		{
+			name:     "Nested arrays",
+			nodePath: []int{0, 0, 1, 1},
+			forms:    [wildcardForms]string{".users[].tags[]", "[.users[].tags[]]", ".users | map(.tags[])"},
+			count:    3,
+		},
+		{
+			name:     "Missing and null parents yield null",
+			nodePath: []int{0, 0, 2, 0},
+			forms:    [wildcardForms]string{".users[].meta.created", "[.users[].meta.created]", ".users | map(.meta.created)"},
+			count:    3,
+		},
+		{
+			name:     "Element itself",
+			nodePath: []int{0, 1},
+			forms:    [wildcardForms]string{".users[]", "[.users[]]", ".users | map(.)"},
+			count:    3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := root
+			for _, i := range tt.nodePath {
+				node = node.Children[i]
+			}
+			general := generalizePath(node.path())
+			for form, expected := range tt.forms {
+				if query := formatJqWildcard(general, form); query != expected {
+					t.Errorf("Form %d: expected %s, got %s", form, expected, query)
+				}
+			}
+			count, err := root.countPathValues(general)
+			if err != nil {
+				t.Fatalf("countPathValues: %v", err)
+			}
+			if count != tt.count {
+				t.Errorf("Expected %d values, got %d", tt.count, count)
+			}
+		})
+	}
+
+	// Iterating a null is an error in jq
+	meta := root.Children[0].Children[0].Children[2]
+	if _, err := root.countPathValues(append(generalizePath(meta.path()), pathSegment{Iterate: true})); err == nil {
+		t.Errorf("Expected an error iterating over null")
+	}
+
+	arrayRoot := mustBuildTree(t, `[{"id": 1}, {"id": 2}]`)
+	general := generalizePath(arrayRoot.Children[1].Children[0].path())
+	if query := formatJqWildcard(general, wildcardMap); query != "map(.id)" {
+		t.Errorf("Expected map(.id), got %s", query)
+	}
+	if query := formatJqWildcard(general, wildcardStream); query != ".[].id" {
+		t.Errorf("Expected .[].id, got %s", query)
+	}
+}
+
+func TestProjectionQueries(t *testing.T) {
+	root := mustBuildTree(t, `{
+		"users": [
+			{"id": 1, "name": "John", "foo-bar": true, "metadata": {"created": "2023", "id": "m1"}, "tags": ["a", "b"]},
+			{"id": 2, "name": "Jane", "foo-bar": false, "metadata": {"created": "2024", "id": "m2"}, "tags": []}
+		],
+		"settings": {"theme": "dark", "debug": false}
+	}`)
+	users := root.Children[0]
+	settings := root.Children[1]
+
+	tests := []struct {
+		name     string
+		nodes    []*JSONNode
+		expected string
+	}{
+		{
+			name:     "Fields of one element",
+			nodes:    []*JSONNode{users.Children[0].Children[0], users.Children[0].Children[1], users.Children[0].Children[3].Children[0]},
+			expected: ".users[] | {id, name, created: .metadata.created}",
+		},
+		{
+			name:     "Fields picked from different elements",
+			nodes:    []*JSONNode{users.Children[0].Children[0], users.Children[1].Children[1]},
+			expected: ".users[] | {id, name}",
+		},
+		{
+			name:     "Key that is not an identifier",
+			nodes:    []*JSONNode{users.Children[0].Children[0], users.Children[0].Children[2]},
+			expected: `.users[] | {id, "foo-bar": .["foo-bar"]}`,
+		},
+		{
+			name:     "Colliding names",
+			nodes:    []*JSONNode{users.Children[0].Children[0], users.Children[0].Children[3].Children[1]},
+			expected: ".users[] | {id, metadata_id: .metadata.id}",
+		},
+		{
+			name:     "Base stops at the deepest shared array",
+			nodes:    []*JSONNode{users.Children[0].Children[3].Children[0], users.Children[0].Children[3].Children[1]},
+			expected: ".users[] | {created: .metadata.created, id: .metadata.id}",
+		},
+		{
+			name:     "Specific index below the base",
+			nodes:    []*JSONNode{users.Children[0].Children[1], users.Children[0].Children[4].Children[1]},
+			expected: ".users[] | {name, tags: .tags[1]}",
+		},
+		{
+			name:     "Shared object without arrays",
+			nodes:    []*JSONNode{settings.Children[0], settings.Children[1]},
+			expected: ".settings | {theme, debug}",
+		},
+		{
+			name:     "Unrelated branches",
+			nodes:    []*JSONNode{users.Children[1].Children[1], settings.Children[0]},
+			expected: "{name: .users[1].name, theme: .settings.theme}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := make([][]pathSegment, len(tt.nodes))
+			for i, node := range tt.nodes {
+				paths[i] = node.path()
+			}
+			if query := formatJqProjection(buildProjection(paths)); query != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, query)
+			}
+		})
+	}
+}
+
+func TestPredicateQueries(t *testing.T) {
+	root := mustBuildTree(t, `{
+		"users": [
+			{"name": "John", "active": true, "age": 30, "meta": {"role": "a.dmin"}},
+			{"name": "Jane \"J\"", "active": false, "age": 25, "meta": null}
+		],
+		"tags": ["x", "y"],
+		"version": 2
+	}`)
+	users := root.Children[0]
+
+	tests := []struct {
+		name     string
+		node     *JSONNode
+		op       string
+		expected string
+	}{
+		{"Boolean equality", users.Children[1].Children[1], "==", ".users[] | select(.active == false)"},
+		{"Not equal", users.Children[0].Children[2], "!=", ".users[] | select(.age != 30)"},
+		{"Less than", users.Children[1].Children[2], "<", ".users[] | select(.age < 25)"},
+		{"Greater than", users.Children[1].Children[2], ">", ".users[] | select(.age > 25)"},
+		{"Quoted string", users.Children[1].Children[0], "==", `.users[] | select(.name == "Jane \"J\"")`},
+		{"Contains", users.Children[0].Children[0], "contains", `.users[] | select(.name | contains("John"))`},
+		{"Regex escapes metacharacters", users.Children[0].Children[3].Children[0], "test", `.users[] | select(.meta.role | test("a\\.dmin"))`},
+		{"Regex on a number", users.Children[0].Children[2], "test", `.users[] | select(.age | tostring | test("30"))`},
+		{"Scalar array element", root.Children[1].Children[1], "==", `.tags[] | select(. == "y")`},
+		{"No enclosing array", root.Children[2], "==", "select(.version == 2)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			literal, err := tt.node.jsonLiteral()
+			if err != nil {
+				t.Fatalf("jsonLiteral: %v", err)
+			}
+			p := buildPredicate(tt.node.path(), tt.node.Value, literal, tt.op)
+			if query := formatJqPredicate(p); query != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, query)
+			}
+		})
+	}
+}
+
+func TestPredicateOperators(t *testing.T) {
+	root := mustBuildTree(t, `{"users": [{"name": "John", "active": true}, {"name": 5, "active": false}]}`)
+	john := root.Children[0].Children[0]
+	m := model{root: root}
+
+	// jq cannot check whether a boolean contains another
+	m.startPredicate(john.Children[1])
+	var ops []string
+	for i := 0; i < len(predicateOps); i++ {
+		ops = append(ops, predicateOps[m.predicateOp])
+		m.cyclePredicateOp()
+	}
+	if got := strings.Join(ops, " "); got != "== != < > test ==" {
+		t.Errorf("Expected contains to be skipped for a boolean, got %s", got)
+	}
+	if !strings.HasSuffix(m.queryNote, "(== != < > test)") {
+		t.Errorf("Expected the note to list the operators offered, got %s", m.queryNote)
+	}
+
+	// Matches are counted in the background, and a failure on other
+	// elements is shown rather than dropping the count
+	m.startPredicate(john.Children[0])
+	stale := m.countMatchesCmd()
+	for predicateOps[m.predicateOp] != "test" {
+		m.cyclePredicateOp()
+	}
+	m = update(m, m.countMatchesCmd()())
+	if !strings.HasPrefix(m.queryNote, "Fails on this input: ") {
+		t.Errorf("Expected test on a number to fail, got %s", m.queryNote)
+	}
+	if m = update(m, stale()); strings.HasPrefix(m.queryNote, "Matches") {
+		t.Errorf("Expected the count of the earlier operator to be dropped, got %s", m.queryNote)
+	}
+}
+
+func TestPathFormats(t *testing.T) {
+	root := mustBuildTree(t, `{
+		"users": [{"name": "John", "active": true}, {"name": "Jane", "active": false}],
+		"a/b": {"c~d": 1, "it's": 2, "": 3}
+	}`)
+	users := root.Children[0]
+	odd := root.Children[1]
+
+	tests := []struct {
+		name     string
+		segs     []pathSegment
+		jsonPath string
+		pointer  string
+	}{
+		{"Root", root.path(), "$", ""},
+		{"Nested index", users.Children[0].Children[0].path(), "$.users[0].name", "/users/0/name"},
+		{"Escaped keys", odd.Children[0].path(), "$['a/b']['c~d']", "/a~1b/c~0d"},
+		{"Quote in key", odd.Children[1].path(), `$['a/b']['it\'s']`, "/a~1b/it's"},
+		{"Empty key", odd.Children[2].path(), "$['a/b']['']", "/a~1b/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := jsonPathFormat.Path(tt.segs); err != nil || got != tt.jsonPath {
+				t.Errorf("Expected JSONPath %s, got %s (%v)", tt.jsonPath, got, err)
+			}
+			if got, err := jsonPointerFormat.Path(tt.segs); err != nil || got != tt.pointer {
+				t.Errorf("Expected pointer %q, got %q (%v)", tt.pointer, got, err)
+			}
+		})
+	}
+
+	name := users.Children[1].Children[0]
+	if got, _ := jsonPathFormat.Wildcard(generalizePath(name.path()), wildcardStream); got != "$.users[*].name" {
+		t.Errorf("Expected $.users[*].name, got %s", got)
+	}
+	if _, err := jsonPointerFormat.Path(generalizePath(name.path())); err == nil {
+		t.Errorf("Expected JSON Pointer to reject a wildcard")
+	}
+
+	active := users.Children[1].Children[1]
+	p := buildPredicate(active.path(), active.Value, "false", "==")
+	if got, err := jsonPathFormat.Predicate(p); err != nil || got != "$.users[?(@.active == false)]" {
+		t.Errorf("Expected JSONPath filter, got %s (%v)", got, err)
+	}
+	p.Op = "test"
+	if _, err := jsonPathFormat.Predicate(p); err == nil {
+		t.Errorf("Expected JSONPath to reject the test operator")
+	}
+}
+
+func TestJMESPathFormat(t *testing.T) {
+	root := mustBuildTree(t, `{
+		"users": [
+			{"id": 1, "name": "John", "active": true, "meta": {"foo-bar": "x"}},
+			{"id": 2, "name": "it's `+"`q`"+`", "active": false, "meta": {"foo-bar": "y"}}
+		],
+		"tags": ["a", "b"],
+		"groups": [{"members": [{"name": "a"}, {"name": "b"}]}, {"members": [{"name": "c"}]}]
+	}`)
+	users := root.Children[0]
+	jane := users.Children[1]
+	member := root.Children[2].Children[1].Children[0].Children[0].Children[0]
+
+	paths := []struct {
+		name     string
+		segs     []pathSegment
+		expected string
+	}{
+		{"Root", root.path(), "@"},
+		{"Index", jane.Children[1].path(), "users[1].name"},
+		{"Quoted key", jane.Children[3].Children[0].path(), `users[1].meta."foo-bar"`},
+		{"Wildcard", generalizePath(jane.Children[1].path()), "users[*].name"},
+		{"Nested wildcard", generalizePath(member.path()), "groups[*].members[].name"},
+	}
+	for _, tt := range paths {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := jmesPathFormat.Path(tt.segs); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	base, fields := buildProjection([][]pathSegment{jane.Children[0].path(), jane.Children[3].Children[0].path()})
+	if got, _ := jmesPathFormat.Projection(base, fields); got != `users[*].{id: id, "foo-bar": meta."foo-bar"}` {
+		t.Errorf("Unexpected projection %s", got)
+	}
+
+	predicates := []struct {
+		name     string
+		node     *JSONNode
+		op       string
+		expected string
+	}{
+		{"Boolean", jane.Children[2], "==", "users[?active == `false`]"},
+		{"Escaped backtick", jane.Children[1], "!=", "users[?name != `\"it's \\`q\\`\"`]"},
+		{"Contains", users.Children[0].Children[1], "contains", "users[?contains(name, `\"John\"`)]"},
+		{"Scalar element", root.Children[1].Children[0], "==", "tags[?@ == `\"a\"`]"},
+	}
+	for _, tt := range predicates {
+		t.Run(tt.name, func(t *testing.T) {
+			literal, err := tt.node.jsonLiteral()
+			if err != nil {
+				t.Fatalf("jsonLiteral: %v", err)
+			}
+			got, err := jmesPathFormat.Predicate(buildPredicate(tt.node.path(), tt.node.Value, literal, tt.op))
+			if err != nil || got != tt.expected {
+				t.Errorf("Expected %s, got %s (%v)", tt.expected, got, err)
+			}
+		})
+	}
+}
+
+func TestCodeFormats(t *testing.T) {
+	root := mustBuildTree(t, `{"users": [{"name": "John", "foo-bar": {"a.b": 1, "class": 2}}]}`)
+	user := root.Children[0].Children[0]
+	name := user.Children[0]
+	dotted := user.Children[1].Children[0]
+	class := user.Children[1].Children[1]
+	nested := mustBuildTree(t, `{"groups": [{"members": [{"n": 1}], "tags": ["a"]}]}`)
+	group := nested.Children[0].Children[0]
+	member := group.Children[0].Children[0].Children[0]
+	tag := group.Children[1].Children[0]
+
+	tests := []struct {
+		name     string
+		format   pathFormat
+		segs     []pathSegment
+		expected string
+	}{
+		{"Python", pythonFormat, name.path(), `data["users"][0]["name"]`},
+		{"Python root", pythonFormat, root.path(), "data"},
+		{"JavaScript", javaScriptFormat, name.path(), "data.users?.[0]?.name"},
+		{"JavaScript quoted", javaScriptFormat, dotted.path(), `data.users?.[0]?.["foo-bar"]?.["a.b"]`},
+		{"JavaScript keyword", javaScriptFormat, class.path(), `data.users?.[0]?.["foo-bar"]?.class`},
+		{"gjson", gjsonFormat, name.path(), "users.0.name"},
+		{"gjson escapes", gjsonFormat, dotted.path(), `users.0.foo-bar.a\.b`},
+		{"gjson wildcard", gjsonFormat, generalizePath(name.path()), "users.#.name"},
+		{"gjson root", gjsonFormat, root.path(), "@this"},
+		{"gjson elements", gjsonFormat, generalizePath(user.path()), "users"},
+		{"gjson nested", gjsonFormat, generalizePath(member.path()), "groups.#.members.#.n|@flatten"},
+		{"gjson nested elements", gjsonFormat, generalizePath(tag.path()), "groups.#.tags|@flatten"},
+		{"gjson root elements", gjsonFormat, generalizePath(group.path())[1:], "@this"},
+		{"yq", yqFormat, dotted.path(), `.users[0]["foo-bar"]["a.b"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.format.Path(tt.segs); err != nil || got != tt.expected {
+				t.Errorf("Expected %s, got %s (%v)", tt.expected, got, err)
+			}
+		})
+	}
+
+	p := buildPredicate(name.path(), name.Value, `"John"`, "==")
+	if got, err := gjsonFormat.Predicate(p); err != nil || got != `users.#(name=="John")#` {
+		t.Errorf("Expected gjson query, got %s (%v)", got, err)
+	}
+}
+
+func TestParseJSONStreams(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"JSON Lines", "{\"a\":1}\n{\"a\":2}\n", `[{"a":1},{"a":2}]`},
+		{"Concatenated", `{"a":1}{"a":2}[3]"x"`, `[{"a":1},{"a":2},[3],"x"]`},
+		{"Pretty-printed", "{\n  \"a\": 1\n}\n{\n  \"a\": [\n    2\n  ]\n}\n", `[{"a":1},{"a":[2]}]`},
+		{"Scalars", "1 2\ttrue null", `[1,2,true,null]`},
+		{"Single value", " {\"a\": 1} ", `{"a":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := parseJSON([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("parseJSON: %v", err)
+			}
+			got, _ := buildJSONTree(data, nil, "").compactJSON()
+			if got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"{\"a\":1}\n{\"a\":\n", "line 2: value is not terminated"},
+		{"{\"a\":1}\n\n{\"a\" 2}\n", "line 3:"},
+		{"{\"a\":1}]", "unexpected closing bracket"},
+		{"  \n", "no valid JSON"},
+	}
+	for _, tt := range errorTests {
+		if _, err := parseJSON([]byte(tt.input)); err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestReadJSONInputLines(t *testing.T) {
+	long := `{"blob": "` + strings.Repeat("x", 100<<10) + `"}`
+	input := "{\"a\": 1}\n" + long + "\nnot json\n\n{\"a\":\n{\"a\": 4}\n"
+
+	parsed, err := readJSONInput([]byte(input))
+	if err != nil {
+		t.Fatalf("readJSONInput: %v", err)
+	}
+	records, _ := parsed.value.([]interface{})
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	if got := fmt.Sprint(parsed.lines); got != "[1 2 6]" {
+		t.Errorf("Expected record lines [1 2 6], got %s", got)
+	}
+	if len(parsed.skipped) != 2 || parsed.skipped[0].line != 3 || parsed.skipped[1].line != 5 {
+		t.Errorf("Expected lines 3 and 5 to be skipped, got %v", parsed.skipped)
+	}
+
+	// A broken document is not taken apart line by line
+	if _, err := readJSONInput([]byte("{\n  \"a\": 1,\n  \"b\": 2\n")); err == nil {
+		t.Errorf("Expected an error for a truncated document")
+	}
+}
+
+func TestStreamRecordLines(t *testing.T) {
+	doc, err := parseDocument([]byte("{\"a\": {\"b\": 1}}\n\n{\n  \"a\": 2\n}\n"), loadOptions{})
+	if err != nil {
+		t.Fatalf("parseDocument: %v", err)
+	}
+	nested := doc.root.Children[0].Children[0].Children[0]
+	if record := nested.record(); record == nil || record.Line != 1 {
+		t.Errorf("Expected record on line 1, got %+v", record)
+	}
+	if record := doc.root.Children[1].record(); record == nil || record.Line != 3 || record.Index != 1 {
+		t.Errorf("Expected second record on line 3, got %+v", record)
+	}
+	if doc.root.record() != nil {
+		t.Errorf("Expected the root not to be a record")
+	}
+
+	// jq only indexes the records as an array when it slurps them
+	m := model{filename: "data.jsonl", doc: doc, root: doc.root}
+	if got, want := m.exampleCommand(".[1].a"), "jq -s '.[1].a' data.jsonl"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if single, err := parseDocument([]byte(`[{"a": 1}]`), loadOptions{}); err != nil || single.stream {
+		t.Errorf("Expected a single array not to be a stream, got %v", err)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	root := mustBuildTree(t, `{"name": "John", "tags": ["a", "b"], "price": 1.50}`)
+	name, tags, price := root.Children[0], root.Children[1], root.Children[2]
+
+	tests := []struct {
+		name     string
+		kind     printKind
+		node     *JSONNode
+		expected string
+	}{
+		{"Query", printQuery, tags.Children[1], ".tags[1]"},
+		{"Raw string", printRaw, name, "John"},
+		{"Raw container", printRaw, tags, `["a","b"]`},
+		{"Raw number", printRaw, price, "1.50"},
+		{"Compact string", printCompact, name, `"John"`},
+		{"Compact container", printCompact, root, `{"name":"John","tags":["a","b"],"price":1.50}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := model{root: root, printMode: true, printKind: tt.kind}
+			if err := m.accept(tt.node); err != nil {
+				t.Fatal(err)
+			}
+			if !m.accepted || m.output != tt.expected {
+				t.Errorf("Expected %s, got %s (accepted %v)", tt.expected, m.output, m.accepted)
+			}
+		})
+	}
+}
+
+func TestCompactJSONLikeJq(t *testing.T) {
+	// Printed as jq -c does, for use in shell pipelines
+	root := mustBuildTree(t, `{"html": "<b>&amp;</b>", "sep": "a\u2028b", "escaped": "\\u2028", "list": ["<i>"]}`)
+	expected := `{"html":"<b>&amp;</b>","sep":"a` + "\u2028" + `b","escaped":"\\u2028","list":["<i>"]}`
+	if got, err := root.compactJSON(); err != nil || got != expected {
+		t.Errorf("Expected %s, got %s (%v)", expected, got, err)
+	}
+	m := model{root: root, printMode: true, printKind: printRaw}
+	if err := m.accept(root.Children[3]); err != nil || m.output != `["<i>"]` {
+		t.Errorf("Expected [\"<i>\"], got %s (%v)", m.output, err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+	quit := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")}
+
+	tests := []struct {
+		name      string
+		printMode bool
+		loadErr   error
+		key       tea.KeyMsg
+		expected  int
+	}{
+		{"Printed selection", true, nil, enter, exitSelected},
+		{"Quit without printing", true, nil, quit, exitCancelled},
+		{"Quit browsing", false, nil, quit, exitSelected},
+		{"Load failure", true, fmt.Errorf("broken input"), quit, exitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := mustBuildTree(t, `{"a": 1}`)
+			m := model{root: root, doc: &document{root: root}, printMode: tt.printMode, loadErr: tt.loadErr}
+			updated, cmd := m.Update(tt.key)
+			if cmd == nil {
+				t.Fatal("Expected the key to quit")
+			}
+			if got := updated.(model).exitCode(); got != tt.expected {
+				t.Errorf("Expected exit code %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	osc52 "github.com/aymanbagabas/go-osc52/v2"
 	"github.com/charmbracelet/bubbles/key"
@@ -39,24 +41,44 @@ var (
 	nullStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#565F89"))
 	keyStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#7AA2F7"))
 	matchStyle  = lipgloss.NewStyle().Background(lipgloss.Color("#9ECE6A")).Foreground(lipgloss.Color("#1A1B26")).Bold(true)
+
+	warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#E0AF68"))
+	commentStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#565F89")).Italic(true)
+
+	errorMarkStyle = lipgloss.NewStyle().Background(lipgloss.Color("#F7768E")).Foreground(lipgloss.Color("#1A1B26"))
+
+	addedStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#73DACA")).Bold(true)
+	changedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#E0AF68"))
+	removedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#F7768E")).Strikethrough(true)
 )
 
 type keyMap struct {
-	Up       key.Binding
-	Down     key.Binding
-	Left     key.Binding
-	Right    key.Binding
-	PageUp   key.Binding
-	PageDown key.Binding
-	Expand   key.Binding
-	Collapse key.Binding
-	Select   key.Binding
-	Copy     key.Binding
-	Search   key.Binding
-	Back     key.Binding
-	Quit     key.Binding
-	Help     key.Binding
-	Wrap     key.Binding
+	Up        key.Binding
+	Down      key.Binding
+	Left      key.Binding
+	Right     key.Binding
+	PageUp    key.Binding
+	PageDown  key.Binding
+	Expand    key.Binding
+	Collapse  key.Binding
+	Select    key.Binding
+	Copy      key.Binding
+	Search    key.Binding
+	Back      key.Binding
+	Quit      key.Binding
+	Help      key.Binding
+	Wrap      key.Binding
+	Sort      key.Binding
+	CopyJSON  key.Binding
+	Filter    key.Binding
+	Wildcard  key.Binding
+	Mark      key.Binding
+	Predicate key.Binding
+	Operator  key.Binding
+	Format    key.Binding
+	CopyAs    key.Binding
+	Follow    key.Binding
+	Rerun     key.Binding
 }
 
 var keys = keyMap{
@@ -98,12 +120,44 @@ var keys = keyMap{
 	),
 	Copy: key.NewBinding(
 		key.WithKeys("y"),
-		key.WithHelp("y", "copy jq query"),
+		key.WithHelp("y", "copy query"),
+	),
+	CopyJSON: key.NewBinding(
+		key.WithKeys("Y"),
+		key.WithHelp("Y", "copy value"),
 	),
 	Search: key.NewBinding(
 		key.WithKeys("/"),
 		key.WithHelp("/", "search"),
 	),
+	Filter: key.NewBinding(
+		key.WithKeys(":"),
+		key.WithHelp(":", "jq filter"),
+	),
+	Wildcard: key.NewBinding(
+		key.WithKeys("*"),
+		key.WithHelp("*", "all elements"),
+	),
+	Mark: key.NewBinding(
+		key.WithKeys("m"),
+		key.WithHelp("m", "mark field"),
+	),
+	Predicate: key.NewBinding(
+		key.WithKeys("f"),
+		key.WithHelp("f", "filter by value"),
+	),
+	Operator: key.NewBinding(
+		key.WithKeys("o"),
+		key.WithHelp("o", "change operator"),
+	),
+	Format: key.NewBinding(
+		key.WithKeys("p"),
+		key.WithHelp("p", "path format"),
+	),
+	CopyAs: key.NewBinding(
+		key.WithKeys("c"),
+		key.WithHelp("c", "copy as…"),
+	),
 	Back: key.NewBinding(
 		key.WithKeys("esc"),
 		key.WithHelp("esc", "back"),
@@ -120,6 +174,18 @@ var keys = keyMap{
 		key.WithKeys("w"),
 		key.WithHelp("w", "toggle wrap"),
 	),
+	Sort: key.NewBinding(
+		key.WithKeys("s"),
+		key.WithHelp("s", "sort keys"),
+	),
+	Follow: key.NewBinding(
+		key.WithKeys("F"),
+		key.WithHelp("F", "auto-scroll"),
+	),
+	Rerun: key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "run again"),
+	),
 }
 
 func (k keyMap) ShortHelp() []key.Binding {
@@ -129,18 +195,192 @@ func (k keyMap) ShortHelp() []key.Binding {
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Up, k.Down, k.Left, k.Right},
-		{k.Select, k.Copy, k.Search, k.Back, k.Quit},
-		{k.Wrap, k.Help},
+		{k.Select, k.Copy, k.CopyJSON, k.CopyAs, k.Wildcard, k.Mark, k.Predicate, k.Operator, k.Format, k.Search, k.Filter, k.Back, k.Quit},
+		{k.Wrap, k.Sort, k.Follow, k.Rerun, k.Help},
 	}
 }
 
 func (m model) Init() tea.Cmd {
+	if m.follow {
+		return startFollowing(m.inputPath)
+	}
+	if m.loading && m.watch {
+		return tea.Batch(startLoading(m.loader()), watchTick())
+	}
+	if m.loading && m.interval > 0 {
+		return tea.Batch(startLoading(m.loader()), rerunTick(m.interval))
+	}
+	if m.loading {
+		return startLoading(m.loader())
+	}
 	return nil
 }
 
+// loader returns how the input is loaded: by running the command, or from
+// the file or stdin
+func (m model) loader() loadFunc {
+	opts := m.options
+	if command := m.command; len(command) > 0 {
+		return func(progress func(read, total int64)) (*document, error) {
+			return loadCommand(command, opts, progress)
+		}
+	}
+	path := m.inputPath
+	return func(progress func(read, total int64)) (*document, error) {
+		return loadInput(path, opts, progress)
+	}
+}
+
+// Update handles msg, then counts the matches of a predicate that changed
+// while handling it
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	seq := m.countSeq
+	updated, cmd := m.update(msg)
+	next, ok := updated.(model)
+	if !ok || next.countSeq == seq {
+		return updated, cmd
+	}
+	if count := next.countMatchesCmd(); count != nil {
+		return next, tea.Batch(cmd, count)
+	}
+	return next, cmd
+}
+
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case loadProgressMsg:
+		m.progress = msg
+		return m, msg.next
+
+	case loadDoneMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.loadErr = msg.err
+			if m.parseErr = asParseError(msg.err); m.parseErr != nil {
+				// Stay to show where the input is broken
+				return m, nil
+			}
+			return m, tea.Quit
+		}
+		m.doc = msg.doc
+		m.root = msg.doc.root
+		if skipped := msg.doc.skipped; len(skipped) > 0 {
+			m.status = fmt.Sprintf("Skipped %d invalid lines; first %v", len(skipped), skipped[0])
+		}
+		if m.sortKeys {
+			m.root.sortChildren(true)
+		}
+		return m, msg.next
+
+	case followMsg:
+		m.appendRecords(msg.records)
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Stopped following: %v", msg.err)
+		}
+		m.followEnded = msg.ended
+		if m.filterActive() {
+			cmd := m.runFilter()
+			return m, tea.Batch(msg.next, cmd)
+		}
+		return m, msg.next
+
+	case watchTickMsg:
+		cmd := m.checkWatchedFile()
+		return m, cmd
+
+	case rerunTickMsg:
+		// A run that is still going is not started again
+		var cmd tea.Cmd
+		if !m.loading && !m.reloading {
+			cmd = m.reload()
+		}
+		return m, tea.Batch(cmd, rerunTick(m.interval))
+
+	case reloadMsg:
+		m.reloading = false
+		if msg.err != nil {
+			if parseErr := asParseError(msg.err); m.parseErr != nil && parseErr != nil {
+				m.loadErr, m.parseErr = msg.err, parseErr
+			}
+			m.status = fmt.Sprintf("Reload failed, showing the previous version: %v", msg.err)
+			return m, nil
+		}
+		if m.root == nil {
+			// The file was broken when it was opened, and is fixed now
+			m.loadErr, m.parseErr = nil, nil
+			return m.Update(loadDoneMsg{doc: msg.doc})
+		}
+		m.applyReload(msg.doc)
+		if m.filterActive() {
+			cmd := m.runFilter()
+			return m, cmd
+		}
+		return m, nil
+
+	case countResultMsg:
+		// Counts of queries that have since changed are stale
+		if msg.seq == m.countSeq {
+			if msg.err != nil {
+				m.queryNote = fmt.Sprintf("Fails on this input: %v • %s", msg.err, m.predicateHelp())
+			} else {
+				m.queryNote = fmt.Sprintf("Matches %d values • %s", msg.count, m.predicateHelp())
+			}
+		}
+		return m, nil
+
+	case filterResultMsg:
+		// Results of filters that have since been edited are stale
+		if msg.seq == m.filterSeq {
+			m.filterRoot = msg.root
+			m.filterErr = ""
+			if msg.err != nil {
+				m.filterErr = msg.err.Error()
+			}
+		}
+		return m, nil
+
 	case tea.KeyMsg:
+		// Only quitting is possible until the input is loaded
+		if m.root == nil {
+			if key.Matches(msg, keys.Quit) || m.parseErr != nil && msg.Type == tea.KeyEsc {
+				return m, tea.Quit
+			}
+			if m.parseErr != nil && m.command != nil && !m.reloading && key.Matches(msg, keys.Rerun) {
+				return m, m.reload()
+			}
+			if m.parseErr != nil && m.parseErr.partial != nil && key.Matches(msg, keys.Select) {
+				// Explore what was read before the error instead
+				doc := &document{root: buildJSONTree(m.parseErr.partial, nil, ""), source: sourceJSON, broken: m.parseErr}
+				m.loadErr, m.parseErr = nil, nil
+				return m.Update(loadDoneMsg{doc: doc})
+			}
+			return m, nil
+		}
+
+		// Handle jq filter input
+		if m.filterMode {
+			switch msg.Type {
+			case tea.KeyCtrlC:
+				return m, tea.Quit
+			case tea.KeyEsc:
+				m.closeFilter()
+				return m, nil
+			case tea.KeyEnter:
+				m.filterMode = false
+				return m, nil
+			case tea.KeyBackspace:
+				if runes := []rune(m.filterText); len(runes) > 0 {
+					m.filterText = string(runes[:len(runes)-1])
+				}
+			case tea.KeyRunes, tea.KeySpace:
+				m.filterText += string(msg.Runes)
+			default:
+				return m, nil
+			}
+			cmd := m.runFilter()
+			return m, cmd
+		}
+
 		// Handle search mode
 		if m.searchMode {
 			switch msg.String() {
@@ -171,6 +411,19 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 
+		// Handle the copy-as menu: a number copies, any other key closes it
+		if m.copyMenu != nil {
+			if msg.Type == tea.KeyCtrlC {
+				return m, tea.Quit
+			}
+			formats := m.copyFormats()
+			if i, err := strconv.Atoi(msg.String()); err == nil && i >= 1 && i <= len(formats) {
+				m.copyAs(formats[i-1])
+			}
+			m.copyMenu = nil
+			return m, nil
+		}
+
 		switch {
 		case key.Matches(msg, keys.Quit):
 			return m, tea.Quit
@@ -205,62 +458,141 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, keys.Left):
 			visibleNodes := m.root.getAllVisibleNodes()
 			if m.cursor < len(visibleNodes) {
-				current := visibleNodes[m.cursor]
-				if current.Type == "object" || current.Type == "array" {
-					current.Expanded = false
-				}
+				m.setExpanded(visibleNodes[m.cursor], false)
 			}
 		case key.Matches(msg, keys.Right):
 			visibleNodes := m.root.getAllVisibleNodes()
 			if m.cursor < len(visibleNodes) {
-				current := visibleNodes[m.cursor]
-				if current.Type == "object" || current.Type == "array" {
-					current.Expanded = true
-				}
+				m.setExpanded(visibleNodes[m.cursor], true)
 			}
 		case key.Matches(msg, keys.Select):
 			visibleNodes := m.root.getAllVisibleNodes()
-			if m.cursor < len(visibleNodes) {
-				m.selected = visibleNodes[m.cursor]
-				m.jqQuery = m.selected.buildJqQuery()
+			if m.cursor < len(visibleNodes) && m.inLatest(visibleNodes[m.cursor]) {
+				if m.printMode {
+					if err := m.accept(visibleNodes[m.cursor]); err != nil {
+						m.status = fmt.Sprintf("Cannot print selection: %v", err)
+						break
+					}
+					return m, tea.Quit
+				}
+				m.selectNode(visibleNodes[m.cursor])
 			}
 		case key.Matches(msg, keys.Copy):
+			switch {
+			case m.queryErr != "":
+				m.status = m.queryErr
+			case m.hasQuery():
+				_, _ = fmt.Fprint(os.Stderr, osc52.New(m.query))
+			}
+		case key.Matches(msg, keys.Format):
+			m.cycleFormat()
+		case key.Matches(msg, keys.CopyAs):
+			m.status = ""
+			if m.selected != nil || len(m.marked) > 0 {
+				m.copyMenu = m.selected
+				if m.copyMenu == nil {
+					m.copyMenu = m.marked[0]
+				}
+			} else if visibleNodes := m.root.getAllVisibleNodes(); m.cursor < len(visibleNodes) && m.inLatest(visibleNodes[m.cursor]) {
+				m.copyMenu = visibleNodes[m.cursor]
+			}
+		case key.Matches(msg, keys.Wildcard):
+			m.status = ""
+			m.cycleWildcard()
+		case key.Matches(msg, keys.Predicate):
+			m.status = ""
+			visibleNodes := m.root.getAllVisibleNodes()
+			if m.cursor < len(visibleNodes) && m.inLatest(visibleNodes[m.cursor]) {
+				m.startPredicate(visibleNodes[m.cursor])
+			}
+		case key.Matches(msg, keys.Operator):
+			m.cyclePredicateOp()
+		case key.Matches(msg, keys.Mark):
+			visibleNodes := m.root.getAllVisibleNodes()
+			if m.cursor < len(visibleNodes) && m.inLatest(visibleNodes[m.cursor]) {
+				m.toggleMark(visibleNodes[m.cursor])
+			}
+		case key.Matches(msg, keys.CopyJSON):
 			if m.selected != nil {
-				query := m.selected.buildJqQuery()
-				m.jqQuery = query
-				_, _ = fmt.Fprint(os.Stderr, osc52.New(query))
+				if value, err := m.selected.compactJSON(); err == nil {
+					_, _ = fmt.Fprint(os.Stderr, osc52.New(value))
+				}
 			}
 		case key.Matches(msg, keys.Search):
 			m.searchMode = true
+		case key.Matches(msg, keys.Filter):
+			// Decoding a lazily indexed input would read all of it on the UI
+			if m.doc != nil && m.doc.lazy {
+				m.status = "The jq filter is not available for inputs this large"
+				break
+			}
+			if _, err := m.document(); err != nil {
+				m.status = fmt.Sprintf("Cannot decode input for jq: %v", err)
+				break
+			}
+			m.filterMode = true
+			cmd := m.runFilter()
+			return m, cmd
+		case key.Matches(msg, keys.Back):
+			switch {
+			case m.filterActive():
+				m.closeFilter()
+			case len(m.marked) > 0:
+				m.clearMarks()
+			default:
+				m.selectNode(nil)
+			}
 		case key.Matches(msg, keys.Help):
 			m.showHelp = !m.showHelp
 		case key.Matches(msg, keys.Wrap):
 			m.wrapValues = !m.wrapValues
+		case key.Matches(msg, keys.Sort):
+			// Keep the cursor on the same node after reordering
+			visibleNodes := m.root.getAllVisibleNodes()
+			var current *JSONNode
+			if m.cursor < len(visibleNodes) {
+				current = visibleNodes[m.cursor]
+			}
+			m.sortKeys = !m.sortKeys
+			m.root.sortChildren(m.sortKeys)
+			for i, node := range m.root.getAllVisibleNodes() {
+				if node == current {
+					m.cursor = i
+					break
+				}
+			}
+		case key.Matches(msg, keys.Follow):
+			if !m.follow {
+				m.status = "Auto-scroll follows a growing input; run with -f"
+				break
+			}
+			m.autoScroll = !m.autoScroll
+			if m.autoScroll {
+				m.cursor = len(m.root.getAllVisibleNodes()) - 1
+			}
+		case key.Matches(msg, keys.Rerun):
+			if m.command == nil {
+				m.status = "Nothing to run again; open a command with jqpick -- command"
+				break
+			}
+			if !m.reloading {
+				return m, m.reload()
+			}
 		case msg.String() == "space":
 			visibleNodes := m.root.getAllVisibleNodes()
 			if m.cursor < len(visibleNodes) {
 				current := visibleNodes[m.cursor]
-				if current.Type == "object" || current.Type == "array" {
-					current.Expanded = !current.Expanded
-				}
+				m.setExpanded(current, !current.Expanded)
 			}
 		}
 
 	case tea.MouseMsg:
-		titleHeight := 2
-		searchHeight := 0
-		if m.searchMode || m.searchTerm != "" {
-			searchHeight = 1
-		}
-		queryHeight := 0
-		if m.jqQuery != "" && m.selected != nil {
-			queryHeight = 4
+		if m.root == nil {
+			return m, nil
 		}
-		helpHeight := 2
-		treeHeight := m.height - titleHeight - searchHeight - queryHeight - helpHeight - 1
-
-		treeStartY := titleHeight + searchHeight
-		if msg.Y >= treeStartY && msg.Y < treeStartY+treeHeight {
+		treeStartY, treeHeight := m.treeLayout()
+		inTree := msg.X < m.treeWidth()
+		if inTree && msg.Y >= treeStartY && msg.Y < treeStartY+treeHeight {
 			visibleNodes := m.root.getAllVisibleNodes()
 			viewHeight := treeHeight - 1
 			startIdx := 0
@@ -291,16 +623,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				switch msg.Type {
 				case tea.MouseLeft:
 					m.cursor = idx
-					if idx >= 0 && idx < len(visibleNodes) {
-						m.selected = visibleNodes[idx]
-						m.jqQuery = m.selected.buildJqQuery()
+					if idx >= 0 && idx < len(visibleNodes) && m.inLatest(visibleNodes[idx]) {
+						m.selectNode(visibleNodes[idx])
 					}
 				case tea.MouseRight:
 					if idx >= 0 && idx < len(visibleNodes) {
 						node := visibleNodes[idx]
-						if node.Type == "object" || node.Type == "array" {
-							node.Expanded = !node.Expanded
-						}
+						m.setExpanded(node, !node.Expanded)
 					}
 				case tea.MouseWheelUp:
 					if m.cursor > 0 {
@@ -322,25 +651,106 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
-func (m model) View() string {
-	if m.showHelp {
-		return m.renderHelp()
+// document returns the decoded input for the jq engine, decoding lazily
+// indexed inputs on first use
+func (m *model) document() (interface{}, error) {
+	if m.filterDoc == nil {
+		doc, err := m.root.value()
+		if err != nil {
+			return nil, err
+		}
+		m.filterDoc = doc
+	}
+	return m.filterDoc, nil
+}
+
+func (m model) filterActive() bool {
+	return m.filterMode || m.filterText != ""
+}
+
+// runFilter starts evaluating the current filter text on the decoded input
+func (m *model) runFilter() tea.Cmd {
+	m.filterSeq++
+	if strings.TrimSpace(m.filterText) == "" {
+		m.filterRoot = nil
+		m.filterErr = ""
+		return nil
 	}
+	doc, err := m.document()
+	if err != nil {
+		m.filterRoot = nil
+		m.filterErr = fmt.Sprintf("Cannot decode input for jq: %v", err)
+		return nil
+	}
+	return evalFilterCmd(m.filterSeq, m.filterText, doc)
+}
 
-	// Calculate heights for each section
+func (m *model) closeFilter() {
+	m.filterMode = false
+	m.filterText = ""
+	m.filterRoot = nil
+	m.filterErr = ""
+	m.filterSeq++
+}
+
+// treeLayout returns the first screen row of the tree view and its height
+func (m model) treeLayout() (int, int) {
 	titleHeight := 2 // title + margin
 	searchHeight := 0
 	if m.searchMode || m.searchTerm != "" {
 		searchHeight = 1
 	}
-	queryHeight := 0
-	if m.jqQuery != "" && m.selected != nil {
-		queryHeight = 4 // header + query + example + margin
+	filterHeight := 0
+	if m.filterActive() {
+		filterHeight = 1
 	}
+	queryHeight := m.queryHeight()
 	helpHeight := 2
 
 	// Tree gets remaining height
-	treeHeight := m.height - titleHeight - searchHeight - queryHeight - helpHeight - 1
+	top := titleHeight + searchHeight + filterHeight
+	return top, m.height - top - queryHeight - helpHeight - 1
+}
+
+// treeWidth is the width of the source tree, which shares the screen with
+// the filter result while a jq filter is active
+func (m model) treeWidth() int {
+	if m.filterActive() {
+		return m.width / 2
+	}
+	return m.width
+}
+
+// setExpanded expands or collapses node, loading its children from the input
+// if needed and reporting failures in the status line
+func (m *model) setExpanded(node *JSONNode, expanded bool) {
+	m.status = ""
+	if expanded && node.Span != nil && !node.Span.loaded && node.removed() {
+		// The previous version of the input it would be read from is closed
+		m.status = "This value was removed in the latest version of the file and cannot be read any more"
+		return
+	}
+	if err := node.setExpanded(expanded); err != nil {
+		m.status = fmt.Sprintf("Cannot expand %s: %v", node.buildJqQuery(), err)
+		return
+	}
+	if expanded && m.sortKeys {
+		node.sortChildren(true)
+	}
+}
+
+func (m model) View() string {
+	if m.parseErr != nil {
+		return m.renderParseError()
+	}
+	if m.root == nil {
+		return m.renderLoading()
+	}
+	if m.showHelp {
+		return m.renderHelp()
+	}
+
+	_, treeHeight := m.treeLayout()
 
 	var sections []string
 
@@ -348,6 +758,8 @@ func (m model) View() string {
 	title := titleStyle.Render("JQPick - Interactive JSON Explorer")
 	if m.searchMode {
 		title = titleStyle.Render("JQPick - Search Mode (type to search, esc to exit)")
+	} else if m.filterMode {
+		title = titleStyle.Render("JQPick - jq Filter (type a filter, esc to close)")
 	}
 	sections = append(sections, title)
 
@@ -360,15 +772,39 @@ func (m model) View() string {
 		sections = append(sections, searchInfo)
 	}
 
-	// JSON Tree View with fixed height
-	treeView := m.renderTreeView(treeHeight)
-	treeStyle := lipgloss.NewStyle().
-		Width(m.width).
-		Height(treeHeight)
-	sections = append(sections, treeStyle.Render(treeView))
+	// Filter bar
+	if m.filterActive() {
+		filterInfo := "jq> " + m.filterText
+		if m.filterMode {
+			filterInfo += "_" // Cursor
+		}
+		sections = append(sections, queryStyle.Render(filterInfo))
+	}
 
-	// JQ Query Display
-	if m.jqQuery != "" && m.selected != nil {
+	// JSON Tree View with fixed height, next to the filter result if any
+	source := m
+	source.width = m.treeWidth()
+	treeView := lipgloss.NewStyle().
+		Width(source.width).
+		MaxWidth(source.width).
+		Height(treeHeight).
+		Render(source.renderTreeView(treeHeight))
+	if m.filterActive() {
+		result := m
+		result.width = m.width - source.width
+		resultView := lipgloss.NewStyle().
+			Width(result.width).
+			MaxWidth(result.width).
+			Height(treeHeight).
+			Render(result.renderFilterResult(treeHeight))
+		treeView = lipgloss.JoinHorizontal(lipgloss.Top, treeView, resultView)
+	}
+	sections = append(sections, treeView)
+
+	// Query Display
+	if m.copyMenu != nil {
+		sections = append(sections, m.renderCopyMenu())
+	} else if m.hasQuery() {
 		querySection := m.renderQuerySection()
 		sections = append(sections, querySection)
 	}
@@ -378,13 +814,39 @@ func (m model) View() string {
 	if m.searchMode {
 		helpLines = append(helpLines, helpStyle.Render("↑/↓ navigate • type to search"))
 		helpLines = append(helpLines, helpStyle.Render("Esc exit search • Enter exit"))
+	} else if m.filterMode {
+		helpLines = append(helpLines, helpStyle.Render("type a jq filter • result updates as you type"))
+		helpLines = append(helpLines, helpStyle.Render("Enter keep result • Esc close filter"))
 	} else {
-		wrapIndicator := ""
+		indicators := ""
 		if m.wrapValues {
-			wrapIndicator = " [wrap: on]"
+			indicators += " [wrap: on]"
+		}
+		if m.sortKeys {
+			indicators += " [sorted]"
+		}
+		if f := pathFormats[m.format]; f.Name != jqFormat.Name {
+			indicators += " [" + f.Name + "]"
+		}
+		if m.follow && m.autoScroll {
+			indicators += " [auto-scroll]"
 		}
-		helpLines = append(helpLines, helpStyle.Render("Mouse: click select • right-click toggle • scroll"))
-		helpLines = append(helpLines, helpStyle.Render("Enter select • y copy • ? help • w wrap • / search • q quit"+wrapIndicator))
+		if m.status != "" {
+			helpLines = append(helpLines, warningStyle.Render(m.status))
+		} else {
+			helpLines = append(helpLines, helpStyle.Render("Mouse: click select • right-click toggle • scroll"))
+		}
+		enterHelp := "Enter select"
+		if m.printMode {
+			enterHelp = "Enter print & exit"
+		}
+		sourceHelp := ""
+		if m.follow {
+			sourceHelp = " • F auto-scroll"
+		} else if m.command != nil {
+			sourceHelp = " • r run again"
+		}
+		helpLines = append(helpLines, helpStyle.Render(enterHelp+" • y copy • p format • ? help • w wrap • s sort • / search • : jq"+sourceHelp+" • q quit"+indicators))
 	}
 	sections = append(sections, lipgloss.JoinVertical(lipgloss.Left, helpLines...))
 
@@ -397,6 +859,114 @@ func (m model) View() string {
 		Render(content)
 }
 
+// renderFilterResult shows the output of the jq filter, or why it failed
+func (m model) renderFilterResult(availableHeight int) string {
+	lines := []string{headerStyle.Render("jq Result")}
+
+	switch {
+	case m.filterErr != "":
+		lines = append(lines, warningStyle.Render(m.filterErr))
+	case m.filterRoot == nil:
+		lines = append(lines, helpStyle.Render("(no results)"))
+	default:
+		nodes := m.filterRoot.getAllVisibleNodes()
+		if limit := availableHeight - 1; limit >= 0 && len(nodes) > limit {
+			nodes = nodes[:limit]
+		}
+		for _, node := range nodes {
+			lines = append(lines, m.renderNode(node, false))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// renderLoading shows how far loading the input has got
+func (m model) renderLoading() string {
+	lines := []string{titleStyle.Render("JQPick - Interactive JSON Explorer")}
+
+	read, total := m.progress.read, m.progress.total
+	if total > 0 {
+		const barWidth = 30
+		filled := int(read * barWidth / total)
+		bar := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
+		lines = append(lines, fmt.Sprintf("Loading %s %s / %s (%d%%)", bar, formatBytes(read), formatBytes(total), read*100/total))
+	} else {
+		lines = append(lines, fmt.Sprintf("Loading... %s read", formatBytes(read)))
+		lines = append(lines, helpStyle.Render("The tree opens when the input ends; run with -f to follow a stream that does not"))
+	}
+	lines = append(lines, helpStyle.Render("q quit"))
+
+	return strings.Join(lines, "\n")
+}
+
+// renderParseError shows why the input could not be parsed and the lines
+// around the place it failed
+func (m model) renderParseError() string {
+	e := m.parseErr
+	lines := []string{titleStyle.Render("JQPick - Cannot Parse Input")}
+
+	where := "Parsing " + e.format + " failed"
+	switch {
+	case e.column > 0:
+		where += fmt.Sprintf(" at %s (byte %d)", e.position(), e.offset)
+	case e.offset >= 0:
+		where += " at " + e.position()
+	}
+	lines = append(lines, headerStyle.Render(where))
+	lines = append(lines, warningStyle.Render(e.err.Error()), "")
+
+	width := len(strconv.Itoa(e.line + excerptContext))
+	for _, l := range e.excerpt {
+		gutter := fmt.Sprintf("%*d │ ", width, l.number)
+		if !l.failed {
+			lines = append(lines, helpStyle.Render(gutter)+l.text)
+			continue
+		}
+		text := l.text
+		if l.mark >= 0 {
+			// Highlight the character the error points at, or a space
+			// past the end of the line
+			_, size := utf8.DecodeRuneInString(text[l.mark:])
+			char := text[l.mark : l.mark+size]
+			if char == "" {
+				char = " "
+			}
+			text = text[:l.mark] + errorMarkStyle.Render(char) + text[l.mark+size:]
+		}
+		lines = append(lines, warningStyle.Render(gutter)+text)
+		if l.mark >= 0 {
+			lines = append(lines, strings.Repeat(" ", lipgloss.Width(gutter)+lipgloss.Width(l.text[:l.mark]))+warningStyle.Render("^"))
+		}
+	}
+
+	lines = append(lines, "")
+	var help []string
+	if e.partial != nil {
+		help = append(help, "Enter explore what was read before the error")
+	}
+	if m.command != nil {
+		help = append(help, "r run again")
+	}
+	help = append(help, "q quit")
+	lines = append(lines, helpStyle.Render(strings.Join(help, " • ")))
+	return strings.Join(lines, "\n")
+}
+
+// formatBytes renders a byte count with a binary unit suffix
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 func (m model) renderTreeView(availableHeight int) string {
 	var visibleNodes []*JSONNode
 	if m.searchMode || m.searchTerm != "" {
@@ -406,7 +976,37 @@ func (m model) renderTreeView(availableHeight int) string {
 	}
 
 	var lines []string
-	header := headerStyle.Render(fmt.Sprintf("JSON Structure (%d nodes)", len(visibleNodes)))
+	title := fmt.Sprintf("JSON Structure (%d nodes)", len(visibleNodes))
+	if m.doc != nil && m.root == m.doc.root && len(m.doc.skipped) > 0 {
+		title += fmt.Sprintf(" • %d invalid lines skipped", len(m.doc.skipped))
+	}
+	if m.follow && m.doc != nil && m.root == m.doc.root {
+		if m.followEnded {
+			title += " • input ended"
+		} else {
+			title += " • following"
+		}
+	}
+	if m.watch && m.doc != nil && m.root == m.doc.root {
+		title += " • watching"
+	}
+	if m.command != nil && m.doc != nil && m.root == m.doc.root {
+		switch {
+		case m.reloading:
+			title += " • running"
+		case m.interval > 0:
+			title += fmt.Sprintf(" • every %v", m.interval)
+		}
+	}
+	if m.cursor < len(visibleNodes) {
+		if record := visibleNodes[m.cursor].record(); record != nil {
+			title += fmt.Sprintf(" • record %d (line %d)", record.Index+1, record.Line)
+		}
+	}
+	header := headerStyle.Render(title)
+	if m.doc != nil && m.root == m.doc.root && m.doc.broken != nil {
+		header += warningStyle.Render(fmt.Sprintf(" • input breaks off at %s", m.doc.broken.position()))
+	}
 	lines = append(lines, header)
 
 	// Calculate viewport (subtract 1 for header)
@@ -445,12 +1045,16 @@ func (m model) renderNode(node *JSONNode, isSelected bool) string {
 	indent := m.getIndent(node)
 	indentLen := len(indent)
 
+	// Values the watched file no longer has are drawn in one style
+	ghost := node.removed()
+	plain := isSelected || ghost
+
 	// Build the display line
 	var parts []string
 	parts = append(parts, indent)
 
 	// Add expand/collapse indicator
-	if node.Type == "object" || node.Type == "array" {
+	if node.expandable() {
 		if node.Expanded {
 			parts = append(parts, "▼")
 		} else {
@@ -462,13 +1066,16 @@ func (m model) renderNode(node *JSONNode, isSelected bool) string {
 
 	// Add key name
 	keyName := node.getDisplayName()
+	if !node.decoded() {
+		keyName += ":"
+	}
 	keyPartLen := 0
 	if node.Key != "" {
-		keyPartLen = len(keyName) + 1 // +1 for colon
-		if isSelected {
-			parts = append(parts, keyName+":")
+		keyPartLen = len(keyName)
+		if plain {
+			parts = append(parts, keyName)
 		} else {
-			styledKey := keyName + ":"
+			styledKey := keyName
 			if m.searchTerm != "" {
 				styledKey = m.highlightMatch(styledKey, keyStyle)
 			} else {
@@ -481,7 +1088,7 @@ func (m model) renderNode(node *JSONNode, isSelected bool) string {
 	// Add value preview
 	valuePreview := node.getValuePreview()
 	var styledValue string
-	if isSelected {
+	if plain {
 		// No color styling when selected - let selectedStyle handle it
 		styledValue = valuePreview
 	} else {
@@ -509,6 +1116,55 @@ func (m model) renderNode(node *JSONNode, isSelected bool) string {
 	} else {
 		parts = append(parts, styledValue)
 	}
+	if marker := changeMarker(node); marker != "" {
+		if plain {
+			parts = append(parts, marker)
+		} else if node.Change == changeAdded {
+			parts = append(parts, addedStyle.Render(marker))
+		} else {
+			parts = append(parts, changedStyle.Render(marker))
+		}
+	}
+
+	if m.isMarked(node) {
+		if plain {
+			parts = append(parts, " ✓")
+		} else {
+			parts = append(parts, queryStyle.Render(" ✓"))
+		}
+	}
+
+	// Flag numbers that jq would round when it reads them as doubles
+	if node.losesPrecision() {
+		if plain {
+			parts = append(parts, " ⚠")
+		} else {
+			parts = append(parts, warningStyle.Render(" ⚠"))
+		}
+	}
+
+	if node.Comment != "" {
+		if plain {
+			parts = append(parts, " // "+node.Comment)
+		} else {
+			parts = append(parts, commentStyle.Render(" // "+node.Comment))
+		}
+	}
+
+	// Name what a string holds, and show JWT timestamps as dates
+	var note string
+	if node.Embed != nil {
+		note = " " + node.Embed.kind
+	} else if date := node.dateNote(); date != "" {
+		note = " (" + date + ")"
+	}
+	if note != "" {
+		if plain {
+			parts = append(parts, note)
+		} else {
+			parts = append(parts, commentStyle.Render(note))
+		}
+	}
 
 	line := strings.Join(parts, "")
 
@@ -520,6 +1176,9 @@ func (m model) renderNode(node *JSONNode, isSelected bool) string {
 	if isSelected {
 		return selectedStyle.Render(line)
 	}
+	if ghost {
+		return removedStyle.Render(line)
+	}
 
 	return line
 }
@@ -669,25 +1328,162 @@ func (m model) getIndent(node *JSONNode) string {
 	return strings.Repeat("  ", level)
 }
 
+// queryHeight is the number of rows taken by the query section, including
+// its margin
+func (m model) queryHeight() int {
+	switch {
+	case m.copyMenu != nil:
+		return lipgloss.Height(m.renderCopyMenu()) + 1
+	case !m.hasQuery():
+		return 0
+	}
+	return lipgloss.Height(m.renderQuerySection()) + 1
+}
+
+// renderCopyMenu lists the copy-as menu node's query in every format
+func (m model) renderCopyMenu() string {
+	lines := []string{headerStyle.Render("Copy as…")}
+	for i, f := range m.copyFormats() {
+		entry := fmt.Sprintf("  %d  %-13s ", i+1, f.Name)
+		query, err := m.queryFor(m.copyMenu, f)
+		if err != nil {
+			lines = append(lines, helpStyle.Render(entry+err.Error()))
+			continue
+		}
+		lines = append(lines, entry+queryStyle.Render(query))
+	}
+	lines = append(lines, helpStyle.Render(fmt.Sprintf("1-%d copy • any other key closes", len(m.copyFormats()))))
+	return strings.Join(lines, "\n")
+}
+
+// copyAs copies the copy-as menu node's query in format f
+func (m *model) copyAs(f pathFormat) {
+	query, err := m.queryFor(m.copyMenu, f)
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+	_, _ = fmt.Fprint(os.Stderr, osc52.New(query))
+	m.status = "Copied as " + f.Name + ": " + query
+}
+
 func (m model) renderQuerySection() string {
 	var lines []string
 
-	header := headerStyle.Render("JQ Query")
+	header := headerStyle.Render(m.queryTitle())
 	lines = append(lines, header)
 
-	if m.selected != nil {
-		path := m.selected.buildJqQuery()
-		query := queryStyle.Render(path)
-		lines = append(lines, query)
+	if m.hasQuery() {
+		switch {
+		case m.queryErr != "":
+			lines = append(lines, warningStyle.Render(m.queryErr))
+		case m.query == "":
+			// Only the JSON Pointer to the whole document is empty
+			lines = append(lines, queryStyle.Render(`""`)+helpStyle.Render(" (empty pointer: the whole document)"))
+		default:
+			lines = append(lines, queryStyle.Render(m.query))
+		}
 
-		// Add example usage
-		example := helpStyle.Render(fmt.Sprintf("Example: cat %s | jq '%s'", m.filename, path))
-		lines = append(lines, example)
+		// Add example usage, and the jq form when another format is active
+		f := pathFormats[m.format]
+		if f.Name == jqFormat.Name {
+			if m.doc != nil && m.doc.quotedCSV {
+				lines = append(lines, helpStyle.Render("jq cannot split quoted CSV fields; convert the file to JSON to run this query"))
+			} else {
+				lines = append(lines, helpStyle.Render("Example: "+m.exampleCommand(m.jqQuery)))
+			}
+			if m.doc != nil && m.doc.broken != nil {
+				lines = append(lines, warningStyle.Render(fmt.Sprintf("jq fails on this input until the error at %s is fixed", m.doc.broken.position())))
+			}
+			if m.doc != nil && m.doc.source == sourceJSONC {
+				lines = append(lines, helpStyle.Render("jq rejects comments and trailing commas; strip them before running it"))
+			}
+			if m.doc != nil && m.doc.source == sourceYAML {
+				if query, err := m.renderQuery(m.yq()); err == nil {
+					lines = append(lines, helpStyle.Render("yq: "+query))
+				}
+			}
+		} else {
+			if f.Usage != nil && m.queryErr == "" {
+				lines = append(lines, helpStyle.Render(f.Usage(m.query)))
+			}
+			if m.jqQuery != "" {
+				lines = append(lines, helpStyle.Render("jq: "+m.jqQuery))
+			}
+		}
+
+		if m.queryNote != "" {
+			lines = append(lines, helpStyle.Render(m.queryNote))
+		}
+
+		if m.queryKind == queryPath && m.selected != nil && m.selected.losesPrecision() {
+			note := "Note: jq rounds this number to a double, so it may not print it as written"
+			lines = append(lines, warningStyle.Render(note))
+		}
 	}
 
 	return strings.Join(lines, "\n")
 }
 
+// exampleCommand shows how to run query with jq against the current input
+func (m model) exampleCommand(query string) string {
+	// convert turns the input into the JSON jqpick shows; placeholder
+	// stands in for the file name when reading stdin
+	convert, placeholder := "", "file.json"
+	if m.doc != nil {
+		switch m.doc.source {
+		case sourceCSV, sourceTSV:
+			// jq reads the rows as raw lines and builds the same objects
+			comma := ','
+			placeholder = "file.csv"
+			if m.doc.source == sourceTSV {
+				comma, placeholder = '\t', "file.tsv"
+			}
+			convert = "jq -Rn " + singleQuote(csvConversion(comma, m.doc.inferTypes))
+		case sourceYAML:
+			// jq reads JSON, so YAML is converted first; a stream is
+			// collected into the array jqpick shows
+			convert, placeholder = "yq -o=json", "file.yaml"
+			if m.doc.stream {
+				convert = "yq -o=json ea '[.]'"
+			}
+		}
+	}
+
+	jq := "jq "
+	if convert == "" && m.doc != nil && m.doc.stream {
+		// The records of a JSON stream are the array jqpick shows only
+		// when jq slurps them
+		jq = "jq -s "
+	}
+	stages := []string{jq + singleQuote(query)}
+	if convert != "" {
+		stages = append([]string{convert}, stages...)
+	}
+	if m.command != nil {
+		// The real command is piped into jq
+		source := commandLine(m.command)
+		if m.doc != nil && m.doc.compressed != nil {
+			source += " | " + m.doc.compressed.command
+		}
+		return source + " | " + strings.Join(stages, " | ")
+	}
+	input := shellQuote(m.filename)
+	if m.doc != nil && m.doc.compressed != nil {
+		// Compressed input is piped through the matching decompressor
+		if m.filename == "" {
+			input = placeholder + m.doc.compressed.ext
+		}
+		return m.doc.compressed.command + " " + input + " | " + strings.Join(stages, " | ")
+	}
+	if m.filename == "" {
+		return "cat " + placeholder + " | " + strings.Join(stages, " | ")
+	}
+	// The first command reads the file itself
+	stages[0] += " " + input
+	return strings.Join(stages, " | ")
+}
+
 func (m model) renderHelp() string {
 	var lines []string
 
@@ -704,14 +1500,38 @@ func (m model) renderHelp() string {
 
 	// Actions
 	lines = append(lines, headerStyle.Render("Actions:"))
-	lines = append(lines, "  Enter   Select node and show jq query")
-	lines = append(lines, "  y       Copy jq query to clipboard")
+	if m.printMode {
+		lines = append(lines, "  Enter   Print the query (or value) to stdout and exit")
+	} else {
+		lines = append(lines, "  Enter   Select node and show jq query")
+	}
+	lines = append(lines, "  y       Copy query to clipboard in the active path format")
+	lines = append(lines, "  p       Cycle path format: jq, JSONPath ($.a[0].b), JSON Pointer (/a/0/b), JMESPath")
+	lines = append(lines, "  c       Copy as: path formats, Python, JavaScript, Go gjson, yq")
+	lines = append(lines, "  Y       Copy selected value as compact JSON")
+	lines = append(lines, "  *       Generalize array indices: .a[].b, [.a[].b], .a | map(.b)")
+	lines = append(lines, "  m       Mark fields to build {a, b: .x.b} from the common array")
+	lines = append(lines, "  f       Filter the array by this value: .a[] | select(.b == 1)")
+	lines = append(lines, "  o       Change the filter operator (== != < > contains test)")
 	lines = append(lines, "  /       Search (start typing)")
+	lines = append(lines, "  :       Evaluate a jq filter, result shown beside the tree")
 	lines = append(lines, "  w       Toggle word wrap for long values")
-	lines = append(lines, "  Esc     Clear selection")
+	lines = append(lines, "  s       Toggle sorted/document key order")
+	lines = append(lines, "  F       Toggle auto-scroll to the newest record (with -f)")
+	lines = append(lines, "  r       Run the command again (with -- command)")
+	lines = append(lines, "  Esc     Clear marks or selection")
 	lines = append(lines, "  ?       Toggle this help")
 	lines = append(lines, "  q       Quit")
 
+	// Markers of a reloaded file
+	if m.watch {
+		lines = append(lines, headerStyle.Render("Changes since the previous version:"))
+		lines = append(lines, "  "+addedStyle.Render("+")+"       Added value")
+		lines = append(lines, "  "+changedStyle.Render("(was x)")+" Changed value, with what it was")
+		lines = append(lines, "  "+changedStyle.Render("~")+"       Collapsed container with changes inside")
+		lines = append(lines, "  "+removedStyle.Render("removed")+" Removed value, shown until the next change")
+	}
+
 	// Mouse
 	lines = append(lines, headerStyle.Render("Mouse:"))
 	lines = append(lines, "  Click   Select node")
@@ -720,9 +1540,26 @@ func (m model) renderHelp() string {
 
 	// Examples
 	lines = append(lines, headerStyle.Render("Examples:"))
+	lines = append(lines, "  jqpick api.json")
 	lines = append(lines, "  cat api.json | jqpick")
 	lines = append(lines, "  echo '{\"users\":[{\"name\":\"John\"}]}' | jqpick")
 	lines = append(lines, "  curl -s https://api.example.com/data | jqpick")
 
 	return strings.Join(lines, "\n")
 }
+
+// shellQuote quotes s for a POSIX shell unless it is a plain word
+func shellQuote(s string) string {
+	plain := s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r))
+	}) == -1
+	if plain {
+		return s
+	}
+	return singleQuote(s)
+}
+
+// singleQuote wraps s in single quotes, escaping any embedded ones
+func singleQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
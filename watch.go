@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// watchInterval is how often a watched file is checked for changes
+const watchInterval = 500 * time.Millisecond
+
+// changeKind is how a node differs from the previous version of a watched
+// file
+type changeKind int
+
+const (
+	unchanged      changeKind = iota
+	changeAdded               // not in the previous version
+	changeModified            // a new value, or a container with changes inside
+	changeRemoved             // only in the previous version, shown in its old place
+)
+
+// watchTickMsg asks to check the watched file for changes
+type watchTickMsg struct{}
+
+// reloadMsg carries a new version of the watched file or the command output
+type reloadMsg struct {
+	doc *document
+	err error
+}
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func watchTick() tea.Cmd {
+	return tea.Tick(watchInterval, func(time.Time) tea.Msg {
+		return watchTickMsg{}
+	})
+}
+
+// stampFile returns the version of the file at path, taken as its load
+// starts so that saves made while it loads are seen by the next check
+func stampFile(path string) fileStamp {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: stat.ModTime(), size: stat.Size()}
+}
+
+// checkWatchedFile reloads the watched file when it has changed since it
+// was last loaded, and schedules the next check
+func (m *model) checkWatchedFile() tea.Cmd {
+	if m.loading || m.reloading {
+		return watchTick()
+	}
+	stamp := stampFile(m.inputPath)
+	if stamp.modTime.IsZero() || (stamp.modTime.Equal(m.watchStamp.modTime) && stamp.size == m.watchStamp.size) {
+		// A missing file may be replaced right now; look again later
+		return watchTick()
+	}
+	return tea.Batch(watchTick(), m.reload())
+}
+
+// reload loads the input again in the background, delivering a reloadMsg
+func (m *model) reload() tea.Cmd {
+	m.reloading = true
+	if m.watch {
+		m.watchStamp = stampFile(m.inputPath)
+	}
+	load := m.loader()
+	return func() tea.Msg {
+		doc, err := load(nil)
+		return reloadMsg{doc: doc, err: err}
+	}
+}
+
+// applyReload replaces the document with a new version of the input.
+// The cursor, selection, marks and expanded containers stay on the same
+// paths, and nodes are marked with how they changed.
+func (m *model) applyReload(doc *document) {
+	var cursorPath []pathSegment
+	if visible := m.root.getAllVisibleNodes(); m.cursor < len(visible) {
+		cursorPath = visible[m.cursor].path()
+	}
+	var selectedPath []pathSegment
+	if m.selected != nil {
+		selectedPath = m.selected.path()
+	}
+	markedPaths := m.markedPaths()
+
+	var changes changeCount
+	diffTree(m.root, doc.root, &changes)
+	if m.sortKeys {
+		// After diffTree, which loads the containers that were expanded
+		doc.root.sortChildren(true)
+	}
+	m.doc.Close()
+	m.doc, m.root = doc, doc.root
+	m.filterDoc = nil
+
+	m.selected = nil
+	if selectedPath != nil {
+		m.selected = m.root.lookup(selectedPath)
+	}
+	m.marked = nil
+	for _, path := range markedPaths {
+		if node := m.root.lookup(path); node != nil {
+			m.marked = append(m.marked, node)
+		}
+	}
+	if m.selected == nil && m.queryKind != queryProjection {
+		m.queryKind = queryPath
+	}
+	m.refreshQuery()
+
+	// Stay on the node at the same path, or the closest one above it
+	m.cursor = 0
+	for len(cursorPath) > 0 {
+		if node := m.root.lookup(cursorPath); node != nil {
+			for i, visible := range m.root.getAllVisibleNodes() {
+				if visible == node {
+					m.cursor = i
+				}
+			}
+			break
+		}
+		cursorPath = cursorPath[:len(cursorPath)-1]
+	}
+	if m.searchTerm != "" {
+		m.updateFilteredNodes()
+	}
+
+	if changes == (changeCount{}) {
+		m.status = fmt.Sprintf("Reloaded at %s: no changes", time.Now().Format("15:04:05"))
+	} else {
+		m.status = fmt.Sprintf("Reloaded at %s: %d changed, %d added, %d removed",
+			time.Now().Format("15:04:05"), changes.modified, changes.added, changes.removed)
+	}
+}
+
+// changeCount tallies the changes found by diffTree
+type changeCount struct {
+	modified int
+	added    int
+	removed  int
+}
+
+// diffTree compares node with old, the node at the same path in the
+// previous version, and does the same for their children: object members by
+// key and array elements by index. Containers are expanded as they were,
+// removed children are kept as ghosts in Removed, and
+// every node gets its Change. It reports whether anything under node
+// changed. Lazily indexed containers and values embedded in strings are
+// only compared as far as both are loaded.
+func diffTree(old, node *JSONNode, changes *changeCount) bool {
+	node.Change, node.Previous = unchanged, ""
+	switch {
+	case old == nil:
+		node.Change = changeAdded
+		changes.added++
+		return true
+	case old.Type != node.Type:
+		node.Change, node.Previous = changeModified, old.getValuePreview()
+		changes.modified++
+		return true
+	case !node.isContainer() && old.Value != node.Value:
+		node.Change, node.Previous = changeModified, old.getValuePreview()
+		changes.modified++
+		if node.Embed == nil || node.Embed != old.Embed {
+			return true
+		}
+		// The values embedded in the strings are compared below
+	case !node.expandable():
+		return false
+	}
+
+	changed := node.Change == changeModified
+	if err := node.setExpanded(old.Expanded); err != nil {
+		return changed
+	}
+	if !old.childrenLoaded() || !node.childrenLoaded() {
+		return changed
+	}
+
+	oldChildren := make(map[string]*JSONNode, len(old.Children))
+	for _, child := range old.Children {
+		oldChildren[child.Key] = child
+	}
+	for _, child := range node.Children {
+		if diffTree(oldChildren[child.Key], child, changes) {
+			changed = true
+		}
+		delete(oldChildren, child.Key)
+	}
+	for _, child := range old.Children {
+		if _, gone := oldChildren[child.Key]; gone {
+			child.Parent, child.Change = node, changeRemoved
+			child.forgetRemoved()
+			node.Removed = append(node.Removed, child)
+			changes.removed++
+			changed = true
+		}
+	}
+
+	if changed {
+		node.Change = changeModified
+	}
+	return changed
+}
+
+// forgetRemoved drops the ghosts of older versions from n's subtree
+func (n *JSONNode) forgetRemoved() {
+	n.Removed = nil
+	for _, child := range n.Children {
+		child.forgetRemoved()
+	}
+}
+
+// childrenLoaded reports whether n's children are in memory
+func (n *JSONNode) childrenLoaded() bool {
+	if n.Embed != nil {
+		return len(n.Children) > 0
+	}
+	return n.Span == nil || n.Span.loaded
+}
+
+// removed reports whether n is, or is inside, a value that the latest
+// version of a watched file no longer has
+func (n *JSONNode) removed() bool {
+	for current := n; current != nil; current = current.Parent {
+		if current.Change == changeRemoved {
+			return true
+		}
+	}
+	return false
+}
+
+// changeMarker describes how node changed in the last reload: added values
+// get a +, changed ones their previous value and collapsed containers with
+// changes inside a ~
+func changeMarker(node *JSONNode) string {
+	switch node.Change {
+	case changeAdded:
+		return " +"
+	case changeRemoved:
+		return " (removed)"
+	case changeModified:
+		if node.Previous != "" {
+			return " (was " + node.Previous + ")"
+		}
+		if !node.Expanded {
+			return " ~"
+		}
+	}
+	return ""
+}
+
+// inLatest reports whether node is in the latest version of the input, and
+// explains in the status line why it cannot be picked when it is not
+func (m *model) inLatest(node *JSONNode) bool {
+	if node.removed() {
+		m.status = "This value was removed in the latest version of the file"
+		return false
+	}
+	return true
+}
+
+// lookup returns the node at path below n, or nil when there is none
+func (n *JSONNode) lookup(path []pathSegment) *JSONNode {
+	node := n
+	for _, seg := range path {
+		var next *JSONNode
+		for _, child := range node.Children {
+			if child.Key == seg.Key {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffTree(t *testing.T) {
+	old := mustBuildTree(t, `{"a": 1, "b": {"c": "x", "d": [1, 2]}, "gone": true}`)
+	old.Children[1].Expanded = false
+	node := mustBuildTree(t, `{"a": 2, "b": {"c": "x", "d": [1]}, "new": null}`)
+
+	var changes changeCount
+	if !diffTree(old, node, &changes) {
+		t.Fatal("Expected the trees to differ")
+	}
+	if changes != (changeCount{modified: 1, added: 1, removed: 2}) {
+		t.Errorf("Unexpected change count %+v", changes)
+	}
+
+	a, b, added := node.Children[0], node.Children[1], node.Children[2]
+	if a.Change != changeModified || a.Previous != "1" || changeMarker(a) != " (was 1)" {
+		t.Errorf("Expected a to have changed from 1, got %v %q", a.Change, a.Previous)
+	}
+	if added.Change != changeAdded || changeMarker(added) != " +" {
+		t.Errorf("Expected new to be added, got %v", added.Change)
+	}
+	if b.Expanded || b.Change != changeModified || changeMarker(b) != " ~" {
+		t.Errorf("Expected b to stay collapsed and be marked as changed, got %v %v", b.Expanded, b.Change)
+	}
+	if c := b.Children[0]; c.Change != unchanged {
+		t.Errorf("Expected b.c to be unchanged, got %v", c.Change)
+	}
+
+	// Removed values are ghosts next to the children, not part of the value
+	if len(node.Removed) != 1 || node.Removed[0].Key != "gone" || !node.Removed[0].removed() {
+		t.Fatalf("Expected gone to be kept as removed, got %v", node.Removed)
+	}
+	d := b.Children[1]
+	if len(d.Children) != 1 || len(d.Removed) != 1 || !d.Removed[0].removed() || d.Children[0].removed() {
+		t.Errorf("Expected d[1] to be removed and d[0] kept, got %d children and %d removed", len(d.Children), len(d.Removed))
+	}
+	visible := node.getAllVisibleNodes()
+	if last := visible[len(visible)-1]; last != node.Removed[0] {
+		t.Errorf("Expected the removed value after the children, got %s", last.Key)
+	}
+}
+
+func TestApplyReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(content string) *document {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		doc, err := loadInput(path, loadOptions{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return doc
+	}
+
+	doc := write(`{"users": [{"name": "a", "id": 1}, {"name": "b", "id": 2}], "port": 80}`)
+	m := model{inputPath: path, watch: true, doc: doc, root: doc.root}
+	name := doc.root.lookup([]pathSegment{{Key: "users"}, {Key: "1"}, {Key: "name"}})
+	m.selectNode(name)
+	m.toggleMark(doc.root.lookup([]pathSegment{{Key: "port"}}))
+	for i, node := range m.root.getAllVisibleNodes() {
+		if node == name {
+			m.cursor = i
+		}
+	}
+
+	m.applyReload(write(`{"users": [{"name": "a", "id": 1}, {"name": "c", "id": 2}]}`))
+	if m.selected == nil || m.selected.Value != "c" || m.selected.Change != changeModified {
+		t.Fatalf("Expected the selection to follow its path to the new value, got %+v", m.selected)
+	}
+	if m.query != ".users[1].name" {
+		t.Errorf("Expected the query to stay .users[1].name, got %s", m.query)
+	}
+	if visible := m.root.getAllVisibleNodes(); visible[m.cursor] != m.selected {
+		t.Errorf("Expected the cursor to stay on the selection, got %s", visible[m.cursor].Key)
+	}
+	if len(m.marked) != 0 {
+		t.Errorf("Expected the mark of the removed port to be dropped, got %d marks", len(m.marked))
+	}
+	ghost := m.root.Removed[0]
+	if m.inLatest(ghost) || m.status == "" {
+		t.Error("Expected the removed port not to be selectable")
+	}
+}
+
+func TestExpandRemovedLazyValue(t *testing.T) {
+	dir := t.TempDir()
+	index := func(name, content string) *document {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, _, err := indexJSON(f, int64(len(content)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &document{root: root, closer: f, lazy: true}
+	}
+
+	doc := index("old.json", `{"keep": 1, "gone": {"a": [1, 2]}}`)
+	m := model{doc: doc, root: doc.root}
+	m.applyReload(index("new.json", `{"keep": 1}`))
+
+	// The ghost's children would be read from the closed old version
+	ghost := m.root.Removed[0]
+	m.setExpanded(ghost, true)
+	if ghost.Expanded || !strings.Contains(m.status, "removed") {
+		t.Errorf("Expected expanding the removed value to be refused, got %v (%s)", ghost.Expanded, m.status)
+	}
+}
+
+func TestApplyReloadSortsLoadedChildren(t *testing.T) {
+	index := func(input string) *document {
+		t.Helper()
+		root, _, err := indexJSON(strings.NewReader(input), int64(len(input)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &document{root: root, lazy: true}
+	}
+
+	doc := index(`{"b": {"z": 1, "a": 2}}`)
+	m := model{doc: doc, root: doc.root, sortKeys: true}
+	m.setExpanded(doc.root.Children[0], true)
+	m.applyReload(index(`{"b": {"z": 1, "a": 3}}`))
+
+	if got := strings.Join(childKeys(m.root.Children[0]), ","); got != "a,z" {
+		t.Errorf("Expected the reloaded keys sorted, got %s", got)
+	}
+}
+
+func TestCheckWatchedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"port": 80}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := model{inputPath: path, watch: true, loading: true, watchStamp: stampFile(path)}
+
+	// A save while the file first loads is found by the first check
+	if err := os.WriteFile(path, []byte(`{"port": 8080}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m.checkWatchedFile()
+	if m.reloading {
+		t.Fatal("Expected no reload while loading")
+	}
+	m.loading = false
+	m.checkWatchedFile()
+	if !m.reloading || m.watchStamp.size != int64(len(`{"port": 8080}`)) {
+		t.Fatalf("Expected the saved file to be reloaded, got %v with %+v", m.reloading, m.watchStamp)
+	}
+
+	m.reloading = false
+	m.checkWatchedFile()
+	if m.reloading {
+		t.Error("Expected no reload of an unchanged file")
+	}
+}
+
+func TestFilterReloadedFile(t *testing.T) {
+	doc, err := loadCommand([]string{"echo", `{"port": 80}`}, loadOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := model{doc: doc, root: doc.root, filterText: ".port"}
+	next, err := loadCommand([]string{"echo", `{"port": 8080}`}, loadOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m = update(m, reloadMsg{doc: next})
+	if m.filterErr != "" || m.filterRoot == nil || m.filterRoot.Value != json.Number("8080") {
+		t.Errorf("Expected the filter to read the new version, got %v (%s)", m.filterRoot, m.filterErr)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeYAML decodes every document of a YAML stream into the values
+// decodeJSON produces, keeping key order. Several documents are returned as
+// an array and reported with stream set.
+func decodeYAML(data []byte) (value interface{}, stream bool, err error) {
+	var docs []interface{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var node yaml.Node
+		if err := dec.Decode(&node); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, false, err
+		}
+		v, err := yamlValue(&node, make(map[*yaml.Node]bool))
+		if err != nil {
+			return nil, false, err
+		}
+		docs = append(docs, v)
+	}
+
+	switch len(docs) {
+	case 0:
+		return nil, false, errors.New("no YAML documents found")
+	case 1:
+		return docs[0], false, nil
+	default:
+		return docs, true, nil
+	}
+}
+
+// yamlValue converts a YAML node. Aliases are expanded in place and merge
+// keys (<<) are applied; active holds the mappings and sequences being
+// converted so recursive aliases are reported instead of looping.
+func yamlValue(node *yaml.Node, active map[*yaml.Node]bool) (interface{}, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(node.Content[0], active)
+	case yaml.AliasNode:
+		if active[node.Alias] {
+			return nil, fmt.Errorf("line %d: alias *%s refers to a value containing itself", node.Line, node.Value)
+		}
+		return yamlValue(node.Alias, active)
+	case yaml.SequenceNode:
+		active[node] = true
+		defer delete(active, node)
+		arr := make([]interface{}, len(node.Content))
+		for i, elem := range node.Content {
+			v, err := yamlValue(elem, active)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = v
+		}
+		return arr, nil
+	case yaml.MappingNode:
+		active[node] = true
+		defer delete(active, node)
+		return yamlMapping(node, active)
+	default:
+		return yamlScalar(node)
+	}
+}
+
+// yamlMapping converts a mapping into an ordered object. Keys are written as
+// strings the way they appear, so 1: and true: become "1" and "true". Keys
+// merged with << come at the merge's position and never override keys of
+// the mapping itself; among merged mappings the first one wins.
+func yamlMapping(node *yaml.Node, active map[*yaml.Node]bool) (interface{}, error) {
+	explicit := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if key := node.Content[i]; !isMergeKey(key) {
+			name, err := yamlKey(key)
+			if err != nil {
+				return nil, err
+			}
+			explicit[name] = true
+		}
+	}
+
+	var obj orderedObject
+	index := make(map[string]int)
+	set := func(key string, value interface{}, override bool) {
+		if i, seen := index[key]; seen {
+			if override {
+				obj[i].Value = value
+			}
+			return
+		}
+		index[key] = len(obj)
+		obj = append(obj, objectField{Key: key, Value: value})
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if isMergeKey(key) {
+			merged, err := yamlMergeSources(value, active)
+			if err != nil {
+				return nil, err
+			}
+			for _, src := range merged {
+				for _, field := range src {
+					if !explicit[field.Key] {
+						set(field.Key, field.Value, false)
+					}
+				}
+			}
+			continue
+		}
+
+		name, _ := yamlKey(key)
+		v, err := yamlValue(value, active)
+		if err != nil {
+			return nil, err
+		}
+		// Like JSON objects, the last duplicate key wins
+		set(name, v, true)
+	}
+
+	if obj == nil {
+		obj = orderedObject{}
+	}
+	return obj, nil
+}
+
+func isMergeKey(key *yaml.Node) bool {
+	return key.Kind == yaml.ScalarNode && key.ShortTag() == "!!merge"
+}
+
+// yamlMergeSources returns the mappings a merge key refers to: a mapping, an
+// alias of one, or a sequence of those
+func yamlMergeSources(node *yaml.Node, active map[*yaml.Node]bool) ([]orderedObject, error) {
+	elems := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		elems = node.Content
+	}
+
+	var sources []orderedObject
+	for _, elem := range elems {
+		v, err := yamlValue(elem, active)
+		if err != nil {
+			return nil, err
+		}
+		obj, ok := v.(orderedObject)
+		if !ok {
+			return nil, fmt.Errorf("line %d: << must merge a mapping or a list of mappings", elem.Line)
+		}
+		sources = append(sources, obj)
+	}
+	return sources, nil
+}
+
+// yamlKey returns a mapping key as a JSON object key. Only scalar keys can
+// be converted.
+func yamlKey(key *yaml.Node) (string, error) {
+	if key.Kind == yaml.AliasNode {
+		key = key.Alias
+	}
+	if key.Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("line %d: mapping keys must be scalars to convert to JSON", key.Line)
+	}
+	if key.ShortTag() == "!!null" {
+		return "null", nil
+	}
+	return key.Value, nil
+}
+
+// yamlScalar converts a scalar by its resolved tag. Numbers keep their
+// literal when it is valid JSON; .inf, .nan and tags JSON has no type for,
+// such as timestamps or custom tags, are kept as strings.
+func yamlScalar(node *yaml.Node) (interface{}, error) {
+	switch node.ShortTag() {
+	case "!!null":
+		return nil, nil
+	case "!!bool":
+		var b bool
+		if err := node.Decode(&b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	case "!!int":
+		if isJSONNumber(node.Value) {
+			return json.Number(node.Value), nil
+		}
+		// Hexadecimal, octal and binary literals
+		var i int64
+		if err := node.Decode(&i); err == nil {
+			return json.Number(strconv.FormatInt(i, 10)), nil
+		}
+		return node.Value, nil
+	case "!!float":
+		if isJSONNumber(node.Value) {
+			return json.Number(node.Value), nil
+		}
+		var f float64
+		if err := node.Decode(&f); err == nil {
+			if formatted := strconv.FormatFloat(f, 'g', -1, 64); isJSONNumber(formatted) {
+				return json.Number(formatted), nil
+			}
+		}
+		return node.Value, nil
+	default:
+		return node.Value, nil
+	}
+}
+
+// isJSONNumber reports whether s is a number literal in JSON syntax
+func isJSONNumber(s string) bool {
+	if s == "" || !json.Valid([]byte(s)) {
+		return false
+	}
+	// json.Valid allows whitespace around the number
+	first, last := s[0], s[len(s)-1]
+	return (first == '-' || first >= '0' && first <= '9') && last >= '0' && last <= '9'
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeYAML(t *testing.T) {
+	input := `
+defaults: &defaults
+  image: nginx
+  replicas: 1
+service:
+  <<: *defaults
+  replicas: 3
+  name: web
+  ports: [80, 443]
+  1: one
+  true: yes
+  ~: nothing
+  big: 123456789012345678901234
+  ratio: 1.50
+  hex: 0x1F
+  octal: 0o17
+  when: 2024-01-02
+  limit: .inf
+`
+	data, stream, err := decodeYAML([]byte(input))
+	if err != nil {
+		t.Fatalf("decodeYAML: %v", err)
+	}
+	if stream {
+		t.Errorf("Expected a single document")
+	}
+
+	root := buildJSONTree(data, nil, "")
+	service := root.Children[1]
+	if got := strings.Join(childKeys(service), ","); got != "image,replicas,name,ports,1,true,null,big,ratio,hex,octal,when,limit" {
+		t.Errorf("Unexpected keys %s", got)
+	}
+
+	expected := map[string]string{
+		"image":    `"nginx"`,
+		"replicas": "3",
+		"1":        `"one"`,
+		"true":     `"yes"`,
+		"null":     `"nothing"`,
+		"big":      "123456789012345678901234",
+		"ratio":    "1.50",
+		"hex":      "31",
+		"octal":    "15",
+		"when":     `"2024-01-02"`,
+		"limit":    `".inf"`,
+	}
+	for _, child := range service.Children {
+		if want, ok := expected[child.Key]; ok {
+			if got := child.getValuePreview(); got != want {
+				t.Errorf("%s: expected %s, got %s", child.Key, want, got)
+			}
+		}
+	}
+}
+
+func TestDecodeYAMLStream(t *testing.T) {
+	data, stream, err := decodeYAML([]byte("kind: Deployment\n---\nkind: Service\n"))
+	if err != nil {
+		t.Fatalf("decodeYAML: %v", err)
+	}
+	if !stream {
+		t.Fatalf("Expected a stream of documents")
+	}
+	root := buildJSONTree(data, nil, "")
+	kind := root.Children[1].Children[0]
+
+	if got, _ := yqStreamFormat.Path(kind.path()); got != "select(document_index == 1) | .kind" {
+		t.Errorf("Unexpected yq path %s", got)
+	}
+	p := buildPredicate(kind.path(), kind.Value, `"Service"`, "==")
+	if got, _ := yqStreamFormat.Predicate(p); got != `select(.kind == "Service")` {
+		t.Errorf("Unexpected yq predicate %s", got)
+	}
+}
+
+func TestDecodeYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"Complex key", "? [a, b]\n: value\n"},
+		{"Recursive alias", "a: &a\n  b: *a\n"},
+		{"Merge of a scalar", "a: &a 1\nb:\n  <<: *a\n"},
+		{"Syntax error", "a: [1, 2\n"},
+		{"Empty", "# nothing\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeYAML([]byte(tt.input)); err == nil {
+				t.Errorf("Expected an error for %q", tt.input)
+			}
+		})
+	}
+}
+
+func TestParseDocumentDetectsYAML(t *testing.T) {
+	doc, err := parseDocument([]byte("users:\n  - name: John\n"), loadOptions{source: sourceAuto})
+	if err != nil {
+		t.Fatalf("parseDocument: %v", err)
+	}
+	if doc.source != sourceYAML {
+		t.Errorf("Expected YAML to be detected")
+	}
+
+	// Broken JSON keeps reporting the JSON error
+	if _, err := parseDocument([]byte(`{"a": 1`), loadOptions{source: sourceAuto}); err == nil || !strings.HasPrefix(err.Error(), "parsing JSON") {
+		t.Errorf("Expected a JSON error, got %v", err)
+	}
+	// Plain text is not taken for a YAML string
+	if _, err := parseDocument([]byte("hello"), loadOptions{source: sourceAuto}); err == nil {
+		t.Errorf("Expected plain text to be rejected")
+	}
+}